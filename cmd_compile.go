@@ -11,9 +11,12 @@ import (
 	"github.com/google/subcommands"
 	"github.com/skx/go.vm/compiler"
 	"github.com/skx/go.vm/lexer"
+	"github.com/skx/go.vm/opcode"
 )
 
 type compileCmd struct {
+	// The number of registers the program may use.
+	registers int
 }
 
 //
@@ -24,13 +27,18 @@ func (*compileCmd) Synopsis() string { return "Compiled a simple.vm program." }
 func (*compileCmd) Usage() string {
 	return `compile :
   Compile the given input file to a series of bytecodes.
+
+  Programs may use 16 registers, unless -registers is given.  The number
+  is recorded in the output, so it needn't be given when the program is
+  executed.
 `
 }
 
 //
-// Flag setup: no flags
+// Flag setup
 //
 func (p *compileCmd) SetFlags(f *flag.FlagSet) {
+	f.IntVar(&p.registers, "registers", opcode.Registers, "The number of registers the program may use.")
 }
 
 //
@@ -55,6 +63,11 @@ func (p *compileCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{
 
 		// Compile it
 		e := compiler.New(l)
+		err = e.SetRegisters(p.registers)
+		if err != nil {
+			fmt.Printf("Error compiling %s - %s\n", file, err.Error())
+			return subcommands.ExitUsageError
+		}
 		e.Compile()
 
 		// Write it out - remove the suffix from the file
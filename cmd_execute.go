@@ -10,6 +10,20 @@ import (
 )
 
 type executeCmd struct {
+	// The environment variables to expose to the program.
+	env string
+
+	// The commands the program may run.
+	system systemFlags
+
+	// The resources the program may use.
+	limits limitFlags
+
+	// Ignore the protection of memory?
+	permissive bool
+
+	// The devices attached to memory.
+	devices deviceFlags
 }
 
 //
@@ -20,13 +34,42 @@ func (*executeCmd) Synopsis() string { return "Executed a simple.vm program." }
 func (*executeCmd) Usage() string {
 	return `execute :
   Execute the bytecodes contained in the given input file.
+
+  Any arguments following '--' are available to the program via the
+  argument traps, along with any environment variables named via -env.
+
+  Programs may only run commands via the 'system' instruction if they are
+  permitted via -allow-system.  Commands are run with only the environment
+  variables named via -env.
+
+  The length of strings, the depth of the stack, and the size of RAM are
+  limited.  The limits may be changed via -max-string, -max-strings,
+  -max-stack and -memory.
+
+  Programs may protect their memory via the 'section' directive, so that
+  writing over their code, or executing their data, faults.  -permissive
+  ignores this.
+
+  A console device may be attached via -console, so that the program may
+  read and write characters by peeking and poking its address, and a timer
+  which raises interrupts may be attached via -timer.
+
+Example:
+
+  $ go.vm execute -env HOME examples/hello.raw -- one two three
+  $ go.vm execute -allow-system /bin/ls examples/system.raw
 `
 }
 
 //
-// Flag setup: no flags
+// Flag setup
 //
 func (p *executeCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.env, "env", "", "A comma-separated list of environment variables to expose to the program.")
+	p.system.register(f)
+	p.limits.register(f)
+	p.devices.register(f)
+	f.BoolVar(&p.permissive, "permissive", false, "Allow the program to access any memory, ignoring the sections it protects.")
 }
 
 //
@@ -34,14 +77,62 @@ func (p *executeCmd) SetFlags(f *flag.FlagSet) {
 //
 func (p *executeCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
+	files, args := parseArgs(f)
+	env := envFlag(p.env)
+
 	//
 	// For each file on the command-line we can now execute it.
 	//
-	for _, file := range f.Args() {
+	for _, file := range files {
 		fmt.Printf("Loading file: %s\n", file)
 		c := cpu.NewCPU()
-		c.LoadFile(file)
-		c.Run()
+		c.SetArgs(append([]string{file}, args...))
+		c.SetEnv(env)
+		c.SetSystemPolicy(p.system.policy(env))
+		err := p.limits.apply(c)
+		if err != nil {
+			fmt.Printf("Error loading %s - %s\n", file, err.Error())
+			return subcommands.ExitUsageError
+		}
+		c.SetPermissive(p.permissive)
+
+		err = p.devices.apply(c)
+		if err != nil {
+			fmt.Printf("Error loading %s - %s\n", file, err.Error())
+			return subcommands.ExitUsageError
+		}
+
+		err = c.LoadFile(file)
+		if err != nil {
+			fmt.Printf("Error loading %s - %s\n", file, err.Error())
+			return subcommands.ExitFailure
+		}
+
+		// Stop if the program failed.
+		status := exitStatus(c.Run())
+		if status != subcommands.ExitSuccess {
+			return status
+		}
 	}
 	return subcommands.ExitSuccess
 }
+
+//
+// exitStatus converts the result of running a program into the status we
+// should exit with.  Faults are reported, along with the subroutines which
+// were active, and have their own status.
+//
+func exitStatus(status int, err error) subcommands.ExitStatus {
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+
+		if f, ok := err.(*cpu.Fault); ok {
+			for _, line := range f.Trace {
+				fmt.Printf("    in %s\n", line)
+			}
+			return subcommands.ExitStatus(f.ExitCode())
+		}
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitStatus(status)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/subcommands"
+	"github.com/skx/go.vm/compiler"
+	"github.com/skx/go.vm/cpu"
+	"github.com/skx/go.vm/lexer"
+	"github.com/skx/go.vm/opcode"
+	"github.com/skx/go.vm/pack"
+)
+
+type packCmd struct {
+	// The executable to write.
+	output string
+
+	// The traps the program may invoke, empty for all.
+	traps string
+
+	// The environment variables to expose to the program.
+	env string
+
+	// The commands the program may run.
+	system systemFlags
+
+	// The number of registers the program may use.
+	registers int
+}
+
+//
+// Glue
+//
+func (*packCmd) Name() string     { return "pack" }
+func (*packCmd) Synopsis() string { return "Build a self-contained executable." }
+func (*packCmd) Usage() string {
+	return `pack :
+  Build a single executable which contains both the given program and the
+  interpreter.  When the executable is launched it runs the program, and
+  any command-line arguments are available to it via the argument traps.
+  Environment variables named via -env are available too.
+
+  The program may only run commands via the 'system' instruction if they
+  are permitted via -allow-system.
+
+  The input may be either a source program, or compiled bytecode with a
+  .raw suffix.  Source programs are compiled for 16 registers, unless
+  -registers is given.
+
+Example:
+
+  $ go.vm pack examples/hello.in -o hello
+  $ go.vm pack examples/trap.stdin.in -o stdin -traps 0x01,0x02
+  $ go.vm pack examples/system.in -o ls -allow-system /bin/ls
+`
+}
+
+//
+// Flag setup
+//
+func (p *packCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.output, "o", "", "The executable to write.")
+	f.StringVar(&p.env, "env", "", "A comma-separated list of environment variables to expose to the program when it runs.")
+	f.StringVar(&p.traps, "traps", "", "A comma-separated list of the traps the program may invoke, the default is all of them.")
+	p.system.register(f)
+	f.IntVar(&p.registers, "registers", opcode.Registers, "The number of registers the program may use.")
+}
+
+//
+// Entry-point.
+//
+func (p *packCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	files, _ := parseArgs(f)
+	if len(files) != 1 {
+		fmt.Printf("Usage: go.vm pack file.in [-o executable]\n")
+		return subcommands.ExitUsageError
+	}
+	file := files[0]
+
+	// Read the file.
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Printf("Error reading %s - %s\n", file, err.Error())
+		return subcommands.ExitFailure
+	}
+
+	payload := &pack.Payload{Program: input}
+
+	// Compile it, unless it is already bytecode.
+	if filepath.Ext(file) != ".raw" {
+		e := compiler.New(lexer.New(string(input)))
+		err = e.SetRegisters(p.registers)
+		if err != nil {
+			fmt.Printf("Error compiling %s - %s\n", file, err.Error())
+			return subcommands.ExitUsageError
+		}
+		e.Compile()
+		payload.Program = e.Output()
+		payload.Symbols = e.Labels()
+	}
+
+	// Parse the traps we'll permit.
+	if p.traps != "" {
+		payload.Traps = []int{}
+		for _, id := range strings.Split(p.traps, ",") {
+			n, perr := strconv.ParseInt(strings.TrimSpace(id), 0, 64)
+			if perr != nil {
+				fmt.Printf("Invalid trap %s - %s\n", id, perr.Error())
+				return subcommands.ExitUsageError
+			}
+			payload.Traps = append(payload.Traps, int(n))
+		}
+	}
+
+	// Record the names of the environment variables we'll expose.
+	for _, name := range strings.Split(p.env, ",") {
+		if strings.TrimSpace(name) != "" {
+			payload.Env = append(payload.Env, strings.TrimSpace(name))
+		}
+	}
+
+	// Record the commands we'll permit.
+	if p.system.allow != "" {
+		policy := p.system.policy(nil)
+		payload.System = &policy
+	}
+
+	// Default to the input-name, without a suffix.
+	output := p.output
+	if output == "" {
+		output = strings.TrimSuffix(file, filepath.Ext(file))
+	}
+
+	// Find ourselves.
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Error finding our executable - %s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+
+	err = pack.Write(exe, output, payload)
+	if err != nil {
+		fmt.Printf("Error writing %s - %s\n", output, err.Error())
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+//
+// runPacked runs the program embedded within our executable.
+//
+func runPacked(p *pack.Payload) subcommands.ExitStatus {
+	c := cpu.NewCPU()
+	c.SetArgs(os.Args)
+	env := envFlag(strings.Join(p.Env, ","))
+	c.SetEnv(env)
+	c.SetTraps(p.Traps)
+
+	if p.System != nil {
+		policy := *p.System
+		for _, name := range p.Env {
+			if val, ok := env[name]; ok {
+				policy.Env = append(policy.Env, name+"="+val)
+			}
+		}
+		c.SetSystemPolicy(policy)
+	}
+
+	err := c.LoadBytes(p.Program)
+	if err != nil {
+		fmt.Printf("Error loading program - %s\n", err.Error())
+		return subcommands.ExitFailure
+	}
+	c.SetSymbols(p.Symbols)
+	return exitStatus(c.Run())
+}
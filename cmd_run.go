@@ -10,9 +10,27 @@ import (
 	"github.com/skx/go.vm/compiler"
 	"github.com/skx/go.vm/cpu"
 	"github.com/skx/go.vm/lexer"
+	"github.com/skx/go.vm/opcode"
 )
 
 type runCmd struct {
+	// The environment variables to expose to the program.
+	env string
+
+	// The commands the program may run.
+	system systemFlags
+
+	// The resources the program may use.
+	limits limitFlags
+
+	// The number of registers the program may use.
+	registers int
+
+	// Ignore the protection of memory?
+	permissive bool
+
+	// The devices attached to memory.
+	devices deviceFlags
 }
 
 //
@@ -24,13 +42,45 @@ func (*runCmd) Usage() string {
 	return `run :
   The run sub-command compiles the given source program, and then executes
   it immediately.
+
+  Any arguments following '--' are available to the program via the
+  argument traps, along with any environment variables named via -env.
+
+  Programs may only run commands via the 'system' instruction if they are
+  permitted via -allow-system.  Commands are run with only the environment
+  variables named via -env.
+
+  The length of strings, the depth of the stack, and the size of RAM are
+  limited.  The limits may be changed via -max-string, -max-strings,
+  -max-stack and -memory.
+
+  Programs may protect their memory via the 'section' directive, so that
+  writing over their code, or executing their data, faults.  -permissive
+  ignores this.
+
+  A console device may be attached via -console, so that the program may
+  read and write characters by peeking and poking its address, and a timer
+  which raises interrupts may be attached via -timer.
+
+  Programs may use 16 registers, unless -registers is given.
+
+Example:
+
+  $ go.vm run -env HOME,USER examples/hello.in -- one two three
+  $ go.vm run -allow-system /bin/ls examples/system.in
 `
 }
 
 //
-// Flag setup: no flags
+// Flag setup
 //
 func (p *runCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.env, "env", "", "A comma-separated list of environment variables to expose to the program.")
+	p.system.register(f)
+	p.limits.register(f)
+	f.IntVar(&p.registers, "registers", opcode.Registers, "The number of registers the program may use.")
+	p.devices.register(f)
+	f.BoolVar(&p.permissive, "permissive", false, "Allow the program to access any memory, ignoring the sections it protects.")
 }
 
 //
@@ -38,10 +88,13 @@ func (p *runCmd) SetFlags(f *flag.FlagSet) {
 //
 func (p *runCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
 
+	files, args := parseArgs(f)
+	env := envFlag(p.env)
+
 	//
 	// For each file on the command-line both compile and execute it.
 	//
-	for _, file := range f.Args() {
+	for _, file := range files {
 		fmt.Printf("Parsing file: %s\n", file)
 
 		// Read the file.
@@ -56,16 +109,48 @@ func (p *runCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) s
 
 		// Compile it.
 		e := compiler.New(l)
+		err = e.SetRegisters(p.registers)
+		if err != nil {
+			fmt.Printf("Error compiling %s - %s\n", file, err.Error())
+			return subcommands.ExitUsageError
+		}
 		e.Compile()
 
 		// Now create a machine to run the compiled program in
 		c := cpu.NewCPU()
 
+		// Expose our arguments and environment
+		c.SetArgs(append([]string{file}, args...))
+		c.SetEnv(env)
+		c.SetSystemPolicy(p.system.policy(env))
+		err = p.limits.apply(c)
+		if err != nil {
+			fmt.Printf("Error loading %s - %s\n", file, err.Error())
+			return subcommands.ExitUsageError
+		}
+		c.SetPermissive(p.permissive)
+
+		err = p.devices.apply(c)
+		if err != nil {
+			fmt.Printf("Error loading %s - %s\n", file, err.Error())
+			return subcommands.ExitUsageError
+		}
+
 		// Load the program
-		c.LoadBytes(e.Output())
+		err = c.LoadBytes(e.Output())
+		if err != nil {
+			fmt.Printf("Error loading %s - %s\n", file, err.Error())
+			return subcommands.ExitFailure
+		}
+
+		// Name subroutines in stack-traces.
+		c.SetSymbols(e.Labels())
 
-		// Run the machine
-		c.Run()
+		// Run the machine, stopping if the program failed.
+		status := exitStatus(c.Run())
+		if status != subcommands.ExitSuccess {
+			return status
+		}
 	}
 	return subcommands.ExitSuccess
 }
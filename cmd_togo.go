@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/subcommands"
+	"github.com/skx/go.vm/togo"
+)
+
+type togoCmd struct {
+	// The file to write the generated program to.
+	output string
+
+	// The environment variables to expose to the program.
+	env string
+
+	// The commands the program may run.
+	system systemFlags
+}
+
+//
+// Glue
+//
+func (*togoCmd) Name() string     { return "togo" }
+func (*togoCmd) Synopsis() string { return "Translate a compiled program to Go." }
+func (*togoCmd) Usage() string {
+	return `togo :
+  Translate the bytecode contained in the given input file into a
+  standalone Go program, which can then be built into a native binary.
+
+  Programs which might write to their own code via 'poke' or 'memcpy',
+  because the address is within the program or is computed as it runs,
+  cannot be translated.  The generated program will run them via the
+  interpreter instead.
+
+  The command-line arguments of the generated program are available via
+  the argument traps, as are environment variables named via -env.  It may
+  only run commands which are permitted via -allow-system.
+
+Example:
+
+  $ go.vm togo examples/loop.raw -o loop.go
+  $ go.vm togo examples/system.raw -o ls.go -allow-system /bin/ls
+`
+}
+
+//
+// Flag setup
+//
+func (p *togoCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&p.output, "o", "", "The file to write the generated program to.")
+	f.StringVar(&p.env, "env", "", "A comma-separated list of environment variables to expose to the program when it runs.")
+	p.system.register(f)
+}
+
+//
+// Entry-point.
+//
+func (p *togoCmd) Execute(_ context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+
+	files, _ := parseArgs(f)
+	if len(files) != 1 {
+		fmt.Printf("Usage: go.vm togo file.raw [-o file.go]\n")
+		return subcommands.ExitUsageError
+	}
+	file := files[0]
+
+	// Read the file.
+	program, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Printf("Error reading %s - %s\n", file, err.Error())
+		return subcommands.ExitFailure
+	}
+
+	// Translate it
+	t := togo.New(program)
+	var names []string
+	for _, name := range strings.Split(p.env, ",") {
+		if strings.TrimSpace(name) != "" {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	t.SetEnv(names)
+	t.SetSystemPolicy(p.system.policy(nil))
+
+	src, err := t.Translate()
+	if err != nil {
+		fmt.Printf("Error translating %s - %s\n", file, err.Error())
+		return subcommands.ExitFailure
+	}
+
+	if ok, reason := t.Translated(); !ok {
+		fmt.Printf("Embedding the interpreter: %s\n", reason)
+	}
+
+	// Default to the input-name, with a .go suffix
+	output := p.output
+	if output == "" {
+		output = strings.TrimSuffix(file, filepath.Ext(file)) + ".go"
+	}
+
+	err = ioutil.WriteFile(output, src, 0644)
+	if err != nil {
+		fmt.Printf("Error writing %s - %s\n", output, err.Error())
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
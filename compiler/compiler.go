@@ -30,12 +30,16 @@ import (
 
 // Compiler contains our compiler-state
 type Compiler struct {
-	l         *lexer.Lexer   // our lexer
-	curToken  token.Token    // current token
-	peekToken token.Token    // next token
-	bytecode  []byte         // generated bytecode
-	labels    map[string]int // holder for labels
-	fixups    map[int]string // holder for fixups
+	l         *lexer.Lexer    // our lexer
+	curToken  token.Token     // current token
+	peekToken token.Token     // next token
+	bytecode  []byte          // generated bytecode
+	labels    map[string]int  // holder for labels
+	fixups    map[int]string  // holder for fixups
+	registers int             // the number of registers available
+	width     int             // the number of bits in an integer
+	sections  []opcode.Region // the regions begun by `section`
+	regions   []opcode.Region // the regions given an explicit range
 }
 
 // New is our constructor
@@ -43,6 +47,8 @@ func New(l *lexer.Lexer) *Compiler {
 	p := &Compiler{l: l}
 	p.labels = make(map[string]int)
 	p.fixups = make(map[int]string)
+	p.registers = opcode.Registers
+	p.width = 16
 
 	// prime the pump.
 	p.nextToken()
@@ -71,7 +77,7 @@ func (p *Compiler) getRegister(input string) byte {
 		panic(err)
 	}
 
-	if (i >= 0) && (i <= 15) {
+	if (i >= 0) && (i < p.registers) {
 		return byte(i)
 	}
 
@@ -80,6 +86,16 @@ func (p *Compiler) getRegister(input string) byte {
 	return 0
 }
 
+// SetRegisters sets the number of registers the program may use, which
+// is recorded in the header of the output.
+func (p *Compiler) SetRegisters(n int) error {
+	if n < 1 || n > opcode.MaxRegisters {
+		return fmt.Errorf("the number of registers must be between 1 and %d", opcode.MaxRegisters)
+	}
+	p.registers = n
+	return nil
+}
+
 // Dump processe the stream of tokens from the lexer and shows the structure
 // of the program.
 func (p *Compiler) Dump() {
@@ -108,6 +124,12 @@ func (p *Compiler) Compile() {
 			// The label points to the current point in our bytecode
 			p.labels[label] = len(p.bytecode)
 
+		case token.BITS:
+			p.bitsOp()
+
+		case token.SECTION:
+			p.sectionOp()
+
 		case token.EXIT:
 			p.exitOp()
 
@@ -123,6 +145,15 @@ func (p *Compiler) Compile() {
 		case token.RET:
 			p.retOp()
 
+		case token.EI:
+			p.bytecode = append(p.bytecode, byte(opcode.INT_ENABLE))
+
+		case token.DI:
+			p.bytecode = append(p.bytecode, byte(opcode.INT_DISABLE))
+
+		case token.IRET:
+			p.bytecode = append(p.bytecode, byte(opcode.INT_RET))
+
 		case token.CALL:
 			p.callOp()
 
@@ -132,9 +163,84 @@ func (p *Compiler) Compile() {
 		case token.IS_STRING:
 			p.isStrOp()
 
+		case token.IS_BYTES:
+			p.regOp(opcode.IS_BYTES)
+
+		case token.BYTES:
+			p.regsOp(opcode.BYTES_NEW, 2)
+
+		case token.GETBYTE:
+			p.regsOp(opcode.BYTES_GET, 3)
+
+		case token.SETBYTE:
+			p.regsOp(opcode.BYTES_SET, 3)
+
+		case token.SLICE:
+			p.regsOp(opcode.BYTES_SLICE, 4)
+
+		case token.APPEND:
+			p.regsOp(opcode.BYTES_APPEND, 2)
+
+		case token.BYTELEN:
+			p.regsOp(opcode.BYTES_LENGTH, 2)
+
+		case token.BYTES2STRING:
+			p.regOp(opcode.BYTES_TOSTRING)
+
+		case token.STRING2BYTES:
+			p.regOp(opcode.STRING_TOBYTES)
+
+		case token.IS_MAP:
+			p.regOp(opcode.IS_MAP)
+
+		case token.MAP:
+			p.regOp(opcode.MAP_NEW)
+
+		case token.MAPGET:
+			p.regsOp(opcode.MAP_GET, 3)
+
+		case token.MAPSET:
+			p.regsOp(opcode.MAP_SET, 3)
+
+		case token.MAPDEL:
+			p.regsOp(opcode.MAP_DELETE, 2)
+
+		case token.HASKEY:
+			p.regsOp(opcode.MAP_HAS, 2)
+
+		case token.KEYS:
+			p.regsOp(opcode.MAP_KEYS, 2)
+
 		case token.STRING2INT:
 			p.str2IntOp()
 
+		case token.STRLEN:
+			p.regsOp(opcode.STRING_LENGTH, 2)
+
+		case token.SUBSTR:
+			p.regsOp(opcode.STRING_SUBSTR, 4)
+
+		case token.INDEXOF:
+			p.regsOp(opcode.STRING_INDEX, 3)
+
+		case token.CHARAT:
+			p.regsOp(opcode.STRING_CHARAT, 3)
+
+		case token.CHR:
+			p.regsOp(opcode.STRING_CHR, 2)
+
+		case token.UPPER:
+			p.regOp(opcode.STRING_UPPER)
+
+		case token.LOWER:
+			p.regOp(opcode.STRING_LOWER)
+
+		case token.TRIM:
+			p.regOp(opcode.STRING_TRIM)
+
+		case token.SPLIT:
+			p.regsOp(opcode.STRING_SPLIT, 3)
+
 		case token.INT2STRING:
 			p.int2StrOp()
 
@@ -168,6 +274,24 @@ func (p *Compiler) Compile() {
 		case token.JMPNZ:
 			p.jumpOp(opcode.JUMP_NZ)
 
+		case token.JL:
+			p.jumpOp(opcode.JUMP_L)
+
+		case token.JG:
+			p.jumpOp(opcode.JUMP_G)
+
+		case token.JLE:
+			p.jumpOp(opcode.JUMP_LE)
+
+		case token.JGE:
+			p.jumpOp(opcode.JUMP_GE)
+
+		case token.JC:
+			p.jumpOp(opcode.JUMP_C)
+
+		case token.JNC:
+			p.jumpOp(opcode.JUMP_NC)
+
 		case token.MEMCPY:
 			p.memcpyOp()
 
@@ -180,12 +304,48 @@ func (p *Compiler) Compile() {
 		case token.POKE:
 			p.pokeOp()
 
+		case token.PEEKW:
+			p.regsOp(opcode.PEEK_WORD, 2)
+
+		case token.POKEW:
+			p.regsOp(opcode.POKE_WORD, 2)
+
+		case token.STORE_MEM:
+			p.regsOp(opcode.STORE_MEM, 2)
+
+		case token.LOAD_MEM:
+			p.loadMemOp()
+
+		case token.MEMSET:
+			p.regsOp(opcode.MEMSET, 3)
+
+		case token.MEMCMP:
+			p.regsOp(opcode.MEMCMP, 3)
+
 		case token.PUSH:
 			p.pushOp()
 
 		case token.POP:
 			p.popOp()
 
+		case token.ENTER:
+			p.enterOp()
+
+		case token.LEAVE:
+			p.bytecode = append(p.bytecode, byte(opcode.STACK_LEAVE))
+
+		case token.LOAD:
+			p.frameOp(opcode.STACK_LOAD)
+
+		case token.SAVE:
+			p.frameOp(opcode.STACK_SAVE)
+
+		case token.GETSP:
+			p.regOp(opcode.STACK_GETSP)
+
+		case token.SETSP:
+			p.regOp(opcode.STACK_SETSP)
+
 		case token.STORE:
 			p.storeOp()
 
@@ -195,6 +355,18 @@ func (p *Compiler) Compile() {
 		case token.PRINT_STR:
 			p.printString()
 
+		case token.READ_LINE:
+			p.regOp(opcode.READ_LINE)
+
+		case token.READ_BYTE:
+			p.regOp(opcode.READ_BYTE)
+
+		case token.READ_CHAR:
+			p.regOp(opcode.READ_CHAR)
+
+		case token.READ_INT:
+			p.regOp(opcode.READ_INT)
+
 		case token.ADD:
 			p.mathOperation(opcode.ADD_OP)
 
@@ -216,6 +388,30 @@ func (p *Compiler) Compile() {
 		case token.OR:
 			p.mathOperation(opcode.OR_OP)
 
+		case token.MOD:
+			p.mathOperation(opcode.MOD_OP)
+
+		case token.SHL:
+			p.mathOperation(opcode.SHL_OP)
+
+		case token.SHR:
+			p.mathOperation(opcode.SHR_OP)
+
+		case token.SAR:
+			p.mathOperation(opcode.SAR_OP)
+
+		case token.ROL:
+			p.mathOperation(opcode.ROL_OP)
+
+		case token.ROR:
+			p.mathOperation(opcode.ROR_OP)
+
+		case token.NOT:
+			p.regOp(opcode.NOT_OP)
+
+		case token.NEG:
+			p.regOp(opcode.NEG_OP)
+
 		default:
 			fmt.Println("Unhandled token: ", p.curToken)
 
@@ -296,6 +492,26 @@ func (p *Compiler) pokeOp() {
 	p.bytecode = append(p.bytecode, byte(addr))
 }
 
+// loadMemOp reads a string from memory, and stores it in a register.
+//
+// Without a length the string ends at the first NUL byte:
+//
+//    load_mem #reg, #addr [, #length]
+//
+func (p *Compiler) loadMemOp() {
+	p.regsOp(opcode.LOAD_MEM, 2)
+
+	length := byte(opcode.NoRegister)
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return
+		}
+		length = p.getRegister(p.curToken.Literal)
+	}
+	p.bytecode = append(p.bytecode, length)
+}
+
 // pushOp stores a stack-push
 func (p *Compiler) pushOp() {
 	// We're looking for an identifier next.
@@ -324,9 +540,190 @@ func (p *Compiler) popOp() {
 	p.bytecode = append(p.bytecode, byte(reg))
 }
 
-// exitOp terminates our interpeter
+// enterOp begins a stack-frame, with the given number of locals.
+func (p *Compiler) enterOp() {
+	if !p.expectPeek(token.INT) {
+		return
+	}
+	n, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
+
+	p.bytecode = append(p.bytecode, byte(opcode.STACK_ENTER))
+	p.bytecode = append(p.bytecode, p.word(n)...)
+}
+
+// frameOp handles the instructions which access an entry of the current
+// stack-frame, as `load #1, 2` or `save #1, -1`.
+func (p *Compiler) frameOp(operation int) {
+	if !p.expectPeek(token.IDENT) {
+		return
+	}
+	reg := p.getRegister(p.curToken.Literal)
+
+	if !p.expectPeek(token.COMMA) {
+		return
+	}
+	if !p.expectPeek(token.INT) {
+		return
+	}
+	offset, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
+
+	p.bytecode = append(p.bytecode, byte(operation))
+	p.bytecode = append(p.bytecode, reg)
+	p.bytecode = append(p.bytecode, p.word(offset)...)
+}
+
+// regOp handles the instructions which take a single register.
+func (p *Compiler) regOp(operation int) {
+	if !p.expectPeek(token.IDENT) {
+		return
+	}
+	reg := p.getRegister(p.curToken.Literal)
+
+	p.bytecode = append(p.bytecode, byte(operation))
+	p.bytecode = append(p.bytecode, reg)
+}
+
+// regsOp handles the instructions which take the given number of
+// registers, separated by commas, such as `substr #0, #1, #2, #3`.
+func (p *Compiler) regsOp(operation int, count int) {
+	regs := []byte{}
+	for len(regs) < count {
+		if len(regs) > 0 && !p.expectPeek(token.COMMA) {
+			return
+		}
+		if !p.expectPeek(token.IDENT) {
+			return
+		}
+		regs = append(regs, p.getRegister(p.curToken.Literal))
+	}
+
+	p.bytecode = append(p.bytecode, byte(operation))
+	p.bytecode = append(p.bytecode, regs...)
+}
+
+// word returns the two-byte (little-endian) encoding of the given number.
+// Negative numbers are stored in two's complement.
+func (p *Compiler) word(n int64) []byte {
+	return []byte{byte(uint16(n) & 0xFF), byte(uint16(n) >> 8)}
+}
+
+// long returns the eight-byte (little-endian) encoding of the given number.
+func (p *Compiler) long(n int64) []byte {
+	out := make([]byte, 8)
+	for i := range out {
+		out[i] = byte(n >> (8 * uint(i)))
+	}
+	return out
+}
+
+// immediate outputs an instruction which takes registers and a number.
+//
+// If the program has wide integers, and the number doesn't fit in two
+// bytes, the wide form of the instruction is used instead.
+func (p *Compiler) immediate(op int, wide int, n int64, regs ...byte) {
+	if p.width != 16 && (n < 0 || n > 0xFFFF) {
+		p.bytecode = append(p.bytecode, byte(wide))
+		p.bytecode = append(p.bytecode, regs...)
+		p.bytecode = append(p.bytecode, p.long(n)...)
+		return
+	}
+
+	p.bytecode = append(p.bytecode, byte(op))
+	p.bytecode = append(p.bytecode, regs...)
+	p.bytecode = append(p.bytecode, p.word(n)...)
+}
+
+// bitsOp selects the width of the integers the program uses, which is
+// recorded in the header of the program.
+func (p *Compiler) bitsOp() {
+	if !p.expectPeek(token.INT) {
+		return
+	}
+
+	n, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if n != 16 && n != 32 && n != 64 {
+		fmt.Printf("Integers may be 16, 32 or 64 bits, not %d\n", n)
+		os.Exit(1)
+	}
+	if len(p.bytecode) != 0 {
+		fmt.Printf("The width of integers must be selected before any instructions\n")
+		os.Exit(1)
+	}
+	p.width = int(n)
+}
+
+// sectionOp protects the memory which follows it, up to the next section
+// or the end of the program, with the given permissions:
+//
+//    section "rx"
+//
+// A range of addresses may be given instead, to protect memory beyond
+// the program:
+//
+//    section "rw", 0x5000, 0x6000
+//
+func (p *Compiler) sectionOp() {
+	if !p.expectPeek(token.STRING) {
+		return
+	}
+	perm, err := opcode.ParsePerm(p.curToken.Literal)
+	if err != nil {
+		fmt.Printf("%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	if !p.peekTokenIs(token.COMMA) {
+		p.sections = append(p.sections, opcode.Region{Start: len(p.bytecode), Perm: perm})
+		return
+	}
+
+	var addrs []int
+	for len(addrs) < 2 {
+		if !p.expectPeek(token.COMMA) || !p.expectPeek(token.INT) {
+			return
+		}
+		n, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
+		addrs = append(addrs, int(n))
+	}
+	if addrs[0] < 0 || addrs[0] > addrs[1] {
+		fmt.Printf("Invalid section from %d to %d\n", addrs[0], addrs[1])
+		os.Exit(1)
+	}
+	p.regions = append(p.regions, opcode.Region{Start: addrs[0], End: addrs[1], Perm: perm})
+}
+
+// exitOp terminates our interpeter, optionally with a status which is
+// either a number or the contents of a register.
 func (p *Compiler) exitOp() {
-	p.bytecode = append(p.bytecode, byte(opcode.EXIT))
+
+	switch {
+	case p.peekTokenIs(token.INT):
+		p.nextToken()
+
+		// Statuses above 100 are reserved for faults.
+		i, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
+		if i < 0 || i > opcode.MaxStatus {
+			fmt.Printf("Exit status %d is outside the range 0-%d\n", i, opcode.MaxStatus)
+			os.Exit(1)
+		}
+
+		// Convert to low/high
+		len1 := i % 256
+		len2 := (i - len1) / 256
+
+		p.bytecode = append(p.bytecode, byte(opcode.EXIT_IMMEDIATE))
+		p.bytecode = append(p.bytecode, byte(len1))
+		p.bytecode = append(p.bytecode, byte(len2))
+
+	case p.peekTokenIs(token.IDENT) && p.isRegister(p.peekToken.Literal):
+		p.nextToken()
+
+		p.bytecode = append(p.bytecode, byte(opcode.EXIT_REG))
+		p.bytecode = append(p.bytecode, p.getRegister(p.curToken.Literal))
+
+	default:
+		p.bytecode = append(p.bytecode, byte(opcode.EXIT))
+	}
 }
 
 // incOp increments the contents of the given register
@@ -393,7 +790,11 @@ func (p *Compiler) isStrOp() {
 	p.bytecode = append(p.bytecode, byte(reg))
 }
 
-// str2IntOp converts the given string-register to an int.
+// str2IntOp converts the given string-register to an int, optionally
+// in the given base:
+//
+//    string2int #reg [, base]
+//
 func (p *Compiler) str2IntOp() {
 	// We're looking for an identifier next.
 	if !p.expectPeek(token.IDENT) {
@@ -403,8 +804,25 @@ func (p *Compiler) str2IntOp() {
 	// Save the register we're storing to.
 	reg := p.getRegister(p.curToken.Literal)
 
-	p.bytecode = append(p.bytecode, byte(opcode.STRING_TOINT))
+	if !p.peekTokenIs(token.COMMA) {
+		p.bytecode = append(p.bytecode, byte(opcode.STRING_TOINT))
+		p.bytecode = append(p.bytecode, byte(reg))
+		return
+	}
+	p.nextToken()
+
+	if !p.expectPeek(token.INT) {
+		return
+	}
+	base, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if base != 0 && (base < 2 || base > 36) {
+		fmt.Printf("The base of a number must be from 2 to 36, or 0, not %d\n", base)
+		os.Exit(1)
+	}
+
+	p.bytecode = append(p.bytecode, byte(opcode.STRING_TOINT_BASE))
 	p.bytecode = append(p.bytecode, byte(reg))
+	p.bytecode = append(p.bytecode, p.word(base)...)
 }
 
 // int2StrOp converts the given int-register to a string.
@@ -421,7 +839,15 @@ func (p *Compiler) int2StrOp() {
 	p.bytecode = append(p.bytecode, byte(reg))
 }
 
-// systemOp runs the (string) command in the given register
+// systemOp runs the (string) command in the given register.
+//
+// If further registers follow the command's output is captured, rather
+// than displayed:
+//
+//    system #cmd, #stdout, #status [, #stderr [, #stdin]]
+//
+// The stderr register may be given as `_`, so that input may be supplied
+// without capturing the errors of the command.
 func (p *Compiler) systemOp() {
 	// We're looking for an identifier next.
 	if !p.expectPeek(token.IDENT) {
@@ -431,8 +857,36 @@ func (p *Compiler) systemOp() {
 	// Save the register
 	reg := p.getRegister(p.curToken.Literal)
 
-	p.bytecode = append(p.bytecode, byte(opcode.STRING_SYSTEM))
-	p.bytecode = append(p.bytecode, byte(reg))
+	if !p.peekTokenIs(token.COMMA) {
+		p.bytecode = append(p.bytecode, byte(opcode.STRING_SYSTEM))
+		p.bytecode = append(p.bytecode, byte(reg))
+		return
+	}
+
+	// Collect the registers which receive the results, and the
+	// optional register holding the input.
+	regs := []byte{reg}
+	for p.peekTokenIs(token.COMMA) && len(regs) < 5 {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return
+		}
+		if p.curToken.Literal == "_" && len(regs) == 3 {
+			regs = append(regs, opcode.NoRegister)
+			continue
+		}
+		regs = append(regs, p.getRegister(p.curToken.Literal))
+	}
+	if len(regs) < 3 {
+		fmt.Printf("system requires both an output and a status register\n")
+		os.Exit(1)
+	}
+	for len(regs) < 5 {
+		regs = append(regs, opcode.NoRegister)
+	}
+
+	p.bytecode = append(p.bytecode, byte(opcode.STRING_SYSTEM_CAPTURE))
+	p.bytecode = append(p.bytecode, regs...)
 }
 
 // isIntOp tests if a register contains an integer
@@ -591,16 +1045,20 @@ func (p *Compiler) mathOperation(operation int) {
 	}
 	p.nextToken()
 
-	// and a final literal
-	if p.curToken.Type != token.IDENT {
-		return
-	}
-	src2 := p.getRegister(p.curToken.Literal)
+	// and a final literal, which may be a number rather than a register
+	switch p.curToken.Type {
+	case token.IDENT:
+		src2 := p.getRegister(p.curToken.Literal)
 
-	p.bytecode = append(p.bytecode, byte(operation))
-	p.bytecode = append(p.bytecode, byte(dst))
-	p.bytecode = append(p.bytecode, byte(src1))
-	p.bytecode = append(p.bytecode, byte(src2))
+		p.bytecode = append(p.bytecode, byte(operation))
+		p.bytecode = append(p.bytecode, byte(dst))
+		p.bytecode = append(p.bytecode, byte(src1))
+		p.bytecode = append(p.bytecode, byte(src2))
+	case token.INT:
+		n, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
+
+		p.immediate(operation+opcode.IMMEDIATE_SHORT, operation+opcode.IMMEDIATE, n, dst, src1)
+	}
 
 }
 
@@ -644,15 +1102,8 @@ func (p *Compiler) storeOp() {
 		}
 	case token.INT:
 		// INT_STORE $REG $NUM1 NUM2
-		p.bytecode = append(p.bytecode, byte(opcode.INT_STORE))
-		p.bytecode = append(p.bytecode, reg)
-
-		// Convert to low/high
 		i, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
-		len1 := i % 256
-		len2 := (i - len1) / 256
-		p.bytecode = append(p.bytecode, byte(len1))
-		p.bytecode = append(p.bytecode, byte(len2))
+		p.immediate(opcode.INT_STORE, opcode.INT_STORE_WIDE, i, reg)
 	case token.IDENT:
 		if p.isRegister(p.curToken.Literal) {
 			// REG_STORE REG_DST REG_SRC
@@ -719,16 +1170,8 @@ func (p *Compiler) cmpOp() {
 		}
 	case token.INT:
 		// CMP_IMMEDIATE $REG $NUM1 NUM2
-		p.bytecode = append(p.bytecode, byte(opcode.CMP_IMMEDIATE))
-		p.bytecode = append(p.bytecode, reg)
-
-		// Convert to low/high
 		i, _ := strconv.ParseInt(p.curToken.Literal, 0, 64)
-
-		len1 := i % 256
-		len2 := (i - len1) / 256
-		p.bytecode = append(p.bytecode, byte(len1))
-		p.bytecode = append(p.bytecode, byte(len2))
+		p.immediate(opcode.CMP_IMMEDIATE, opcode.CMP_IMMEDIATE_WIDE, i, reg)
 	case token.IDENT:
 		if p.isRegister(p.curToken.Literal) {
 			// CMP_REG REG_DST REG_SRC
@@ -821,16 +1264,38 @@ func (p *Compiler) dataOp() {
 	}
 }
 
-// Handle printing the contents of a register as an integer.
+// Handle printing the contents of a register as an integer, optionally
+// in the given format:
+//
+//    print_int #reg [, "format"]
+//
 func (p *Compiler) printInt() {
 
 	// We're looking for an identifier next.
 	if !p.expectPeek(token.IDENT) {
 		return
 	}
+	reg := p.getRegister(p.curToken.Literal)
 
-	p.bytecode = append(p.bytecode, byte(opcode.INT_PRINT))
-	p.bytecode = append(p.bytecode, p.getRegister(p.curToken.Literal))
+	if !p.peekTokenIs(token.COMMA) {
+		p.bytecode = append(p.bytecode, byte(opcode.INT_PRINT))
+		p.bytecode = append(p.bytecode, reg)
+		return
+	}
+	p.nextToken()
+
+	if !p.expectPeek(token.STRING) {
+		return
+	}
+	format, err := opcode.ParseFormat(p.curToken.Literal)
+	if err != nil {
+		fmt.Printf("Invalid format for print_int: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	p.bytecode = append(p.bytecode, byte(opcode.INT_PRINT_FMT))
+	p.bytecode = append(p.bytecode, reg)
+	p.bytecode = append(p.bytecode, p.word(int64(format))...)
 }
 
 // Handle printing the contents of a register as a string.
@@ -870,14 +1335,34 @@ func (p *Compiler) peekError(t token.Type) {
 // Write outputs our generated bytecode to the named file.
 func (p *Compiler) Write(output string) {
 	fmt.Printf("Our bytecode is %d bytes long\n", len(p.bytecode))
-	err := ioutil.WriteFile(output, p.bytecode, 0644)
+	err := ioutil.WriteFile(output, p.Output(), 0644)
 	if err != nil {
 		fmt.Printf("Error writing output file: %s\n", err.Error())
 		os.Exit(1)
 	}
 }
 
-// Output returns the bytecodes of the compiled program.
+// Labels returns the labels found in the program, and their addresses.
+func (p *Compiler) Labels() map[string]int {
+	return p.labels
+}
+
+// Output returns the bytecodes of the compiled program, preceded by a
+// header if the program requires one.
 func (p *Compiler) Output() []byte {
-	return (p.bytecode)
+	header := opcode.Header{Width: p.width, Registers: p.registers}
+
+	// Each section ends where the next begins.
+	for i, r := range p.sections {
+		r.End = len(p.bytecode)
+		if i+1 < len(p.sections) {
+			r.End = p.sections[i+1].Start
+		}
+		if r.Start < r.End {
+			header.Regions = append(header.Regions, r)
+		}
+	}
+	header.Regions = append(header.Regions, p.regions...)
+
+	return append(header.Bytes(), p.bytecode...)
 }
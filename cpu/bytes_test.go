@@ -0,0 +1,70 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// Test the byte-array instructions, via the registers they leave behind.
+func TestBytes(t *testing.T) {
+
+	src := `
+        store #0, 3
+        bytes #1, #0
+        store #2, 1
+        store #3, 0x141
+        setbyte #1, #2, #3
+        store #4, "bc"
+        append #1, #4
+        append #1, #3
+        append #1, #1
+        bytelen #5, #1
+        getbyte #6, #1, #2
+        store #7, #1
+        setbyte #7, #2, #2
+        cmp #1, #7
+        jmpz bad
+        push #1
+        slice #8, #1, #2, #0
+        bytes2string #8
+        is_bytes #1
+        jmpnz bad
+        store #9, #1
+        cmp #1, #9
+        jmpnz bad
+        exit 0
+:bad
+        exit 1
+`
+	c := NewCPU()
+	c.LoadBytes(compile(t, src))
+	status, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if status != 0 {
+		t.Errorf("a comparison, or type-check, failed")
+	}
+
+	b, err := c.getBytes(1)
+	if err != nil || string(b.Value) != "\x00A\x00bcA\x00A\x00bcA" {
+		t.Errorf("register #1 holds %q (%v)", b.Value, err)
+	}
+	ints := map[int]int{5: 12, 6: 'A'}
+	for reg, expected := range ints {
+		val, err := c.getInt(reg)
+		if err != nil || val != expected {
+			t.Errorf("register #%d holds %d (%v), expected %d", reg, val, err, expected)
+		}
+	}
+	str, err := c.getString(8)
+	if err != nil || str != "A\x00b" {
+		t.Errorf("register #8 holds %q (%v)", str, err)
+	}
+
+	// Copies are independent of the original.
+	pushed, _ := c.stack.Peek()
+	b.Value[0] = 'x'
+	if pushed.(*BytesObject).Value[0] != 0 {
+		t.Errorf("the stack shares the byte-array of a register")
+	}
+}
@@ -0,0 +1,104 @@
+// This file contains the call stack, which is distinct from the stack
+// used by `push` and `pop`.
+//
+// Each `call` records a frame upon the call stack, holding the address
+// to return to along with the frame-pointer of the caller.  As the data
+// stack cannot hold return-addresses a subroutine which forgets to `pop`
+// a value still returns to the right place.
+//
+// The frames are also used to produce a stack-trace when a fault occurs.
+
+package cpu
+
+import (
+	"fmt"
+	"sort"
+)
+
+// frame is a single entry on the call stack.
+type frame struct {
+	// The address of the subroutine which was called.
+	target int
+
+	// The address of the `call` instruction.
+	site int
+
+	// The address to return to.
+	ret int
+
+	// The frame-pointer of the caller.
+	fp int
+
+	// Set if the frame was recorded by an interrupt, along with the
+	// flags which were interrupted.  See interrupt.go.
+	irq   bool
+	flags Flags
+}
+
+// symbol is the name of an address within the program.
+type symbol struct {
+	name string
+	addr int
+}
+
+// SetSymbols sets the names of the addresses within the program, which
+// are used to describe subroutines in stack-traces.  The compiler makes
+// these available via its Labels method.
+func (c *CPU) SetSymbols(labels map[string]int) {
+	c.symbols = nil
+	for name, addr := range labels {
+		c.symbols = append(c.symbols, symbol{name: name, addr: addr})
+	}
+	sort.Slice(c.symbols, func(i, j int) bool {
+		if c.symbols[i].addr == c.symbols[j].addr {
+			return c.symbols[i].name < c.symbols[j].name
+		}
+		return c.symbols[i].addr < c.symbols[j].addr
+	})
+}
+
+// describe returns the name of the given address, relative to the label
+// which precedes it.  If there are no labels the address is returned.
+func (c *CPU) describe(addr int) string {
+	i := sort.Search(len(c.symbols), func(i int) bool {
+		return c.symbols[i].addr > addr
+	})
+	if i == 0 {
+		return fmt.Sprintf("%04X", addr)
+	}
+
+	sym := c.symbols[i-1]
+	if sym.addr == addr {
+		return sym.name
+	}
+	return fmt.Sprintf("%s+0x%X", sym.name, addr-sym.addr)
+}
+
+// trace describes the call stack, starting with the innermost subroutine.
+func (c *CPU) trace() []string {
+	var out []string
+	for i := len(c.calls) - 1; i >= 0; i-- {
+		f := c.calls[i]
+		if f.irq {
+			out = append(out, fmt.Sprintf("%s, interrupting %s", c.describe(f.target), c.describe(f.site)))
+			continue
+		}
+		out = append(out, fmt.Sprintf("%s, called from %s", c.describe(f.target), c.describe(f.site)))
+	}
+	return out
+}
+
+// call records a new frame upon the call stack, if the limits allow.
+func (c *CPU) call(target int, site int, ret int) error {
+	if c.limits.MaxStack > 0 && len(c.calls) >= c.limits.MaxStack {
+		return fault(StackOverflowFault, "Call Stack Overflow!")
+	}
+	c.calls = append(c.calls, frame{target: target, site: site, ret: ret, fp: c.fp})
+	return nil
+}
+
+// CallDepth returns the number of subroutines which have been called, and
+// have not yet returned.
+func (c *CPU) CallDepth() int {
+	return len(c.calls)
+}
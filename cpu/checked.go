@@ -0,0 +1,58 @@
+// This file contains the functions used by the programs which togo
+// generates, so that they apply the same checks, and raise the same
+// faults, as the interpreter.
+
+package cpu
+
+// GetInt returns the integer held in the given register, or a fault if
+// the register doesn't exist or holds something else.
+func (c *CPU) GetInt(reg int) (int, error) {
+	return c.getInt(reg)
+}
+
+// GetString returns the string held in the given register, or a fault if
+// the register doesn't exist or holds something else.
+func (c *CPU) GetString(reg int) (string, error) {
+	return c.getString(reg)
+}
+
+// SetString stores a string in the given register, or returns a fault if
+// it would exceed our limits.
+func (c *CPU) SetString(reg int, str string) error {
+	return c.setString(reg, str)
+}
+
+// Push adds values to the stack, or returns a fault if they would exceed
+// our limits.  The last value is pushed last.
+func (c *CPU) Push(values ...Object) error {
+	return c.push(values...)
+}
+
+// Pop pops a value from the stack into the given register, or returns a
+// fault if the stack is empty or the value would exceed our limits.
+func (c *CPU) Pop(reg int) error {
+	return c.pop(reg)
+}
+
+// Limits returns the limits on the resources the program may use.
+func (c *CPU) Limits() Limits {
+	return c.limits
+}
+
+// LoadMem returns the n bytes of RAM beginning at addr, or a fault if
+// they lie outside RAM.
+func (c *CPU) LoadMem(addr int, n int) ([]byte, error) {
+	return c.loadMem(addr, n)
+}
+
+// StoreMem writes the given bytes to RAM beginning at addr, or returns a
+// fault if they would lie outside RAM.
+func (c *CPU) StoreMem(addr int, data []byte) error {
+	return c.storeMem(addr, data)
+}
+
+// CheckValue returns a fault if the given integer doesn't fit in n bytes
+// of RAM.
+func CheckValue(val int, n int) error {
+	return checkValue(val, n)
+}
@@ -1,46 +1,106 @@
 // Package cpu contains the CPU for our virtual machine interpreter.
 //
-// We should probably use the constants defined in `opcodes/opcodes.go`
-// instead of the literal hex-constants for our bytecode, but that's a minor
-// issue.
+// Rather than re-reading the bytecode from RAM every time an instruction
+// is executed we decode each instruction once, the first time it is
+// reached, and cache the result.  Execution then consists of looking up
+// the decoded instruction at the current IP and calling the handler for
+// that opcode - see `decode.go` and `ops.go`.
 //
 package cpu
 
 import (
-	"bytes"
+	"bufio"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"os"
-	"os/exec"
-	"strconv"
-	"time"
+	"sync/atomic"
 
 	"github.com/skx/go.vm/opcode"
 )
 
-// Flags holds the CPU flags - of which we only have one.
-type Flags struct {
-	// Zero-flag
-	z bool
-}
-
 // CPU is our virtual machine state.
 type CPU struct {
 	// Registers
-	regs [15]*Register
+	regs []*Register
 
-	// Flags
+	// Flags, see flags.go
 	flags Flags
 
-	// Our RAM - where the program is loaded
-	mem [0xFFFF]byte
+	// The width of our integers, see width.go
+	width Width
+
+	// Our RAM - where the program is loaded, see memory.go
+	mem []byte
+
+	// The permissions of each address, nil if memory is unprotected.
+	// See protect.go.
+	perms []byte
+
+	// Set if the permissions are ignored.
+	permissive bool
+
+	// The devices mapped into memory, see device.go.
+	devices []mapping
+
+	// The devices which are run after every instruction.
+	tickers []Ticker
+
+	// Are interrupts enabled?  See interrupt.go.
+	ie bool
+
+	// The interrupts which have been raised, one bit for each.  This is
+	// accessed atomically, as the host may raise interrupts at any time.
+	pending uint32
 
 	// Instruction-pointer
 	ip int
 
 	// stack
 	stack *Stack
+
+	// The call stack, see calls.go
+	calls []frame
+
+	// The frame-pointer, the offset within the stack of the first
+	// local variable of the current subroutine.
+	fp int
+
+	// The names of addresses within the program.
+	symbols []symbol
+
+	// Decoded instructions, see decode.go
+	code []instruction
+
+	// Offset of the decoded instruction for each address, plus one.
+	index []int32
+
+	// Is the given address part of a decoded instruction?
+	covered []bool
+
+	// Set when the `exit` instruction is executed.
+	halted bool
+
+	// The status the program exited with.
+	status int
+
+	// The command-line arguments available to the program.
+	args []string
+
+	// The environment variables available to the program.
+	env map[string]string
+
+	// The stream the program reads input from, nil for STDIN.  See
+	// input.go.
+	input *bufio.Reader
+
+	// The traps the program may invoke, nil if all are permitted.
+	traps map[int]bool
+
+	// The policy for running commands, see system.go.
+	system SystemPolicy
+
+	// The limits on the resources the program may use, see limits.go.
+	limits Limits
 }
 
 //
@@ -49,8 +109,9 @@ type CPU struct {
 
 // NewCPU returns a new CPU object.
 func NewCPU() *CPU {
-	x := &CPU{}
-	x.Reset()
+	x := &CPU{limits: DefaultLimits, width: Width16}
+	x.regs = make([]*Register, opcode.Registers)
+	x.SetMemory(DefaultMemory)
 	return x
 }
 
@@ -63,728 +124,198 @@ func (c *CPU) Reset() {
 		c.regs[i] = NewRegister()
 	}
 
-	// Reset stack
+	// Reset stacks
 	c.stack = NewStack()
+	c.calls = nil
+	c.fp = 0
+
+	// Reset flags
+	c.flags = Flags{}
+
+	// Disable interrupts, and forget any which were raised
+	c.ie = false
+	atomic.StoreUint32(&c.pending, 0)
+
+	// Forget any decoded instructions
+	c.flush()
 
 	// Reset instruction pointer to zero.
 	c.ip = 0
 }
 
+// Registers returns the registers of the CPU.
+//
+// This is used by programs generated by `go.vm togo`, so that they can
+// share their registers with our trap-functions.
+func (c *CPU) Registers() []*Register {
+	return c.regs
+}
+
+// SetRegisters changes the number of registers the CPU has.  The
+// registers are reset to zero.
+//
+// LoadBytes sets the number the program was compiled for, so this need
+// only be used by programs which are not loaded that way.
+func (c *CPU) SetRegisters(n int) error {
+	if n < 1 || n > opcode.MaxRegisters {
+		return fmt.Errorf("the number of registers must be between 1 and %d", opcode.MaxRegisters)
+	}
+	c.regs = make([]*Register, n)
+	for i := range c.regs {
+		c.regs[i] = NewRegister()
+	}
+	return nil
+}
+
+// Stack returns the stack of the CPU, allowing debuggers to inspect it.
+func (c *CPU) Stack() *Stack {
+	return c.stack
+}
+
+// SetArgs sets the command-line arguments which are available to the
+// program, via the argument traps.  By convention the first argument is
+// the name of the program.
+func (c *CPU) SetArgs(args []string) {
+	c.args = args
+}
+
+// SetEnv sets the environment variables which are available to the
+// program, via the environment trap.  None are available by default.
+func (c *CPU) SetEnv(env map[string]string) {
+	c.env = env
+}
+
+// SetTraps restricts the program to invoking only the given traps.
+//
+// Invoking any other trap is treated as if it had not been defined.
+// Passing nil allows all traps to be used.
+func (c *CPU) SetTraps(ids []int) {
+	if ids == nil {
+		c.traps = nil
+		return
+	}
+
+	c.traps = make(map[int]bool)
+	for _, id := range ids {
+		c.traps[id] = true
+	}
+}
+
 // LoadFile loads the program from the named file into RAM.
 // NOTE: The CPU-state is reset prior to the load.
-func (c *CPU) LoadFile(path string) {
+func (c *CPU) LoadFile(path string) error {
 
 	// Load the file
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
-		fmt.Printf("Failed to read file: %s - %s\n", path, err.Error())
-		os.Exit(1)
-	}
-
-	if len(b) >= 0xFFFF {
-		fmt.Printf("Program too large for RAM!\n")
-		os.Exit(1)
+		return fmt.Errorf("failed to read file: %s - %s", path, err.Error())
 	}
 
 	// Copy contents of file to our memory region.
 	// NOTE: This calls `Reset` too :)
-	c.LoadBytes(b)
+	return c.LoadBytes(b)
 }
 
 // LoadBytes populates the given program into RAM.
 // NOTE: The CPU-state is reset prior to the load.
-func (c *CPU) LoadBytes(data []byte) {
+//
+// If the program has a header the settings it records are applied, and
+// the bytecode which follows it is loaded at address zero.
+func (c *CPU) LoadBytes(data []byte) error {
 
 	// Ensure we reset our state.
 	c.Reset()
 
-	if len(data) >= 0xFFFF {
-		fmt.Printf("Program too large for RAM!\n")
-		os.Exit(1)
+	header, data, err := opcode.ParseHeader(data)
+	if err != nil {
+		return err
 	}
-
-	// Copy contents of file to our memory region
-	for i := 0; i < len(data); i++ {
-		c.mem[i] = data[i]
+	c.width = Width16
+	if header.Width != 0 {
+		c.width = Width(header.Width)
 	}
-}
-
-// Read a string from the IP position
-// Strings are prefixed by their lengths (two-bytes).
-func (c *CPU) readString() string {
-	// Read the length of the string we expect
-	len := c.read2Val()
 
-	// Now build up the body of the string
-	s := ""
-	for i := 0; i < len; i++ {
-		s += string(c.mem[c.ip+i])
+	// Programs without a header use the default number of registers.
+	n := opcode.Registers
+	if header.Registers != 0 {
+		n = header.Registers
+	}
+	if n != len(c.regs) {
+		err = c.SetRegisters(n)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Jump the IP over the length of the string.
-	c.ip += (len)
-	return s
-}
+	if len(data) > len(c.mem) {
+		return fmt.Errorf("program too large for RAM")
+	}
 
-// Read a two-byte number from the current IP.
-// i.e This reads two bytes and returns a 16-bit value to the caller,
-// skipping over both bytes in the IP.
-func (c *CPU) read2Val() int {
-	l := int(c.mem[c.ip])
-	c.ip++
-	h := int(c.mem[c.ip])
-	c.ip++
-
-	val := l + h*256
-	return (val)
+	// Copy contents of file to our memory region
+	copy(c.mem, data)
+
+	// Protect the regions the program describes.
+	c.perms = nil
+	for _, r := range header.Regions {
+		err = c.Protect(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Run launches our intepreter.
-// It does not terminate until an `EXIT` instruction is hit.
-func (c *CPU) Run() {
-	run := true
-	for run {
-
-		op := opcode.NewOpcode(c.mem[c.ip])
-		debugPrintf("%04X %02X [%s]\n", c.ip, op.Value(), op.String())
-
-		switch int(op.Value()) {
-		case opcode.EXIT:
-			run = false
-
-		case opcode.INT_STORE:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if reg > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			c.ip++
-			val := c.read2Val()
-			c.regs[reg].SetInt(val)
-
-		case opcode.INT_PRINT:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			val := c.regs[reg].GetInt()
-			if val < 256 {
-				fmt.Printf("%02X", val)
-			} else {
-				fmt.Printf("%04X", val)
-			}
-			c.ip++
-
-		case opcode.INT_TOSTRING:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			// get value
-			i := c.regs[reg].GetInt()
-
-			// change from int to string
-			c.regs[reg].SetString(fmt.Sprintf("%d", i))
-
-			// next instruction
-			c.ip++
-
-		case opcode.INT_RANDOM:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			// New random source
-			s1 := rand.NewSource(time.Now().UnixNano())
-			r1 := rand.New(s1)
-
-			// New random number
-			c.regs[reg].SetInt(r1.Intn(0xffff))
-			c.ip++
-
-		case opcode.JUMP_TO:
-			c.ip++
-			addr := c.read2Val()
-			c.ip = addr
-
-		case opcode.JUMP_Z:
-			c.ip++
-			addr := c.read2Val()
-			if c.flags.z {
-				c.ip = addr
-			}
-
-		case opcode.JUMP_NZ:
-			c.ip++
-			addr := c.read2Val()
-			if !c.flags.z {
-				c.ip = addr
-			}
-
-		case opcode.XOR_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			// store result
-			aVal := c.regs[a].GetInt()
-			bVal := c.regs[b].GetInt()
-			c.regs[res].SetInt(aVal ^ bVal)
-
-		case opcode.ADD_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			// store result
-			aVal := c.regs[a].GetInt()
-			bVal := c.regs[b].GetInt()
-			c.regs[res].SetInt(aVal + bVal)
-
-		case opcode.SUB_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			// store result
-			aVal := c.regs[a].GetInt()
-			bVal := c.regs[b].GetInt()
-			c.regs[res].SetInt(aVal - bVal)
-
-			// set the zero-flag if the result was zero or less
-			if c.regs[res].GetInt() <= 0 {
-				c.flags.z = true
-			}
-
-		case opcode.MUL_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			// store result
-			aVal := c.regs[a].GetInt()
-			bVal := c.regs[b].GetInt()
-			c.regs[res].SetInt(aVal * bVal)
-
-		case opcode.DIV_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			// store result
-			aVal := c.regs[a].GetInt()
-			bVal := c.regs[b].GetInt()
-
-			if bVal == 0 {
-				fmt.Printf("Attempting to divide by zero - denying\n")
-				os.Exit(3)
-			}
-			c.regs[res].SetInt(aVal / bVal)
-
-		case opcode.INC_OP:
-
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			// get the value
-			val := c.regs[reg].GetInt()
-
-			// if the value is the max it will wrap around
-			if val == 0xFFFF {
-				val = 0
-			} else {
-				// otherwise be incremented normally
-				val++
-			}
-
-			// zero?
-			c.flags.z = (val == 0)
-
-			c.regs[reg].SetInt(val)
-
-			// bump past that
-			c.ip++
-
-		case opcode.DEC_OP:
-
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			// get the value
-			val := c.regs[reg].GetInt()
-
-			// if the value is the minimum it will wrap around
-			if val == 0x0000 {
-				val = 0xFFFF
-			} else {
-				// otherwise decrease normally
-				val--
-			}
-
-			// zero?
-			c.flags.z = (val == 0)
-
-			c.regs[reg].SetInt(val)
-
-			// bump past that
-			c.ip++
-
-		case opcode.AND_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			// store result
-			aVal := c.regs[a].GetInt()
-			bVal := c.regs[b].GetInt()
-			c.regs[res].SetInt(aVal & bVal)
-
-		case opcode.OR_OP:
-			c.ip++
-			res := c.mem[c.ip]
-			c.ip++
-			a := c.mem[c.ip]
-			c.ip++
-			b := c.mem[c.ip]
-			c.ip++
-
-			// store result
-			aVal := c.regs[a].GetInt()
-			bVal := c.regs[b].GetInt()
-			c.regs[res].SetInt(aVal | bVal)
-
-		case opcode.STRING_STORE:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			// bump past that to the length + string
-			c.ip++
-
-			// read it
-			str := c.readString()
-
-			// store the string
-			c.regs[reg].SetString(str)
-
-		case opcode.STRING_PRINT:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			fmt.Printf("%s", c.regs[reg].GetString())
-			c.ip++
-
-		case opcode.STRING_CONCAT:
-			// output register
-			c.ip++
-			res := c.mem[c.ip]
-
-			// src1
-			c.ip++
-			a := c.mem[c.ip]
-
-			// src2
-			c.ip++
-			b := c.mem[c.ip]
-
-			c.ip++
-
-			aVal := c.regs[a].GetString()
-			bVal := c.regs[b].GetString()
-
-			c.regs[res].SetString(aVal + bVal)
-
-		case opcode.STRING_SYSTEM:
-			// register
-			c.ip++
-			r := c.mem[c.ip]
-			c.ip++
-
-			// run the command
-			toExec := splitCommand(c.regs[r].GetString())
-			cmd := exec.Command(toExec[0], toExec[1:]...)
-
-			var out bytes.Buffer
-			var err bytes.Buffer
-			cmd.Stdout = &out
-			cmd.Stderr = &err
-			cmd.Run()
-
-			// stdout
-			fmt.Printf("%s", out.String())
-
-			// stderr - if non-empty
-			if len(err.String()) > 0 {
-				fmt.Printf("%s", err.String())
-			}
-
-		case opcode.STRING_TOINT:
-			// register
-			c.ip++
-			reg := c.mem[c.ip]
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			// get value
-			s := c.regs[reg].GetString()
-
-			i, err := strconv.Atoi(s)
-			if err == nil {
-				c.regs[reg].SetInt(i)
-			} else {
-				fmt.Printf("Failed to convert '%s' to int: %s", s, err.Error())
-				os.Exit(3)
-			}
-
-			// next instruction
-			c.ip++
-
-		case opcode.CMP_REG:
-			c.ip++
-			r1 := int(c.mem[c.ip])
-			c.ip++
-			r2 := int(c.mem[c.ip])
-			c.ip++
-
-			c.flags.z = false
-
-			switch c.regs[r1].Type() {
-			case "int":
-				if c.regs[r1].GetInt() == c.regs[r2].GetInt() {
-					c.flags.z = true
-				}
-			case "string":
-				if c.regs[r1].GetString() == c.regs[r2].GetString() {
-					c.flags.z = true
-				}
-			}
-
-		case opcode.CMP_IMMEDIATE:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			c.ip++
-			val := c.read2Val()
-
-			if c.regs[reg].Type() == "int" && c.regs[reg].GetInt() == val {
-				c.flags.z = true
-			} else {
-				c.flags.z = false
-			}
-
-		case opcode.CMP_STRING:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			c.ip++
-
-			// read it
-			str := c.readString()
-
-			if c.regs[reg].Type() == "string" && c.regs[reg].GetString() == str {
-				c.flags.z = true
-			} else {
-				c.flags.z = false
-			}
-
-		case opcode.IS_STRING:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			c.ip++
-
-			if c.regs[reg].Type() == "string" {
-				c.flags.z = true
-			} else {
-				c.flags.z = false
-			}
-
-		case opcode.IS_INTEGER:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			c.ip++
-
-			if c.regs[reg].Type() == "int" {
-				c.flags.z = true
-			} else {
-				c.flags.z = false
-			}
-
-		case opcode.NOP_OP:
-			c.ip++
-
-		case opcode.REG_STORE:
-			// register
-			c.ip++
-			dst := int(c.mem[c.ip])
-			c.ip++
-
-			// register
-			src := int(c.mem[c.ip])
-			c.ip++
-
-			// Copy the register - paying attention to types
-			if c.regs[src].Type() == "string" {
-				c.regs[dst].SetString(c.regs[src].GetString())
-			} else if c.regs[src].Type() == "int" {
-				c.regs[dst].SetInt(c.regs[src].GetInt())
-			} else {
-				fmt.Printf("Invalid register type?")
-				os.Exit(3)
-			}
-
-		case opcode.PEEK:
-			// register
-			c.ip++
-			result := int(c.mem[c.ip])
-
-			c.ip++
-			src := int(c.mem[c.ip])
-
-			// get the address from the src register contents
-			addr := c.regs[src].GetInt()
-
-			// store the contents of the given address
-			c.regs[result].SetInt(int(c.mem[addr]))
-			c.ip++
-
-		case opcode.POKE:
-
-			// register
-			c.ip++
-			src := int(c.mem[c.ip])
-			c.ip++
-
-			dst := int(c.mem[c.ip])
-			c.ip++
-
-			// So the destination will contain an address
-			// put the contents of the source to that.
-			addr := c.regs[dst].GetInt()
-			val := c.regs[src].GetInt()
-
-			c.mem[addr] = byte(val)
-
-		case opcode.MEMCPY:
-			// register
-			c.ip++
-			dst := int(c.mem[c.ip])
-			c.ip++
-
-			src := int(c.mem[c.ip])
-			c.ip++
-
-			len := int(c.mem[c.ip])
-			c.ip++
-
-			// get the addresses from the registers
-			srcAddr := c.regs[src].GetInt()
-			dstAddr := c.regs[dst].GetInt()
-			length := c.regs[len].GetInt()
-
-			i := 0
-			for i < length {
-
-				if dstAddr >= 0xFFFF {
-					dstAddr = 0
-				}
-				if srcAddr >= 0xFFFF {
-					srcAddr = 0
-				}
-
-				c.mem[dstAddr] = c.mem[srcAddr]
-				dstAddr++
-				srcAddr++
-				i++
-			}
-
-		case opcode.STACK_PUSH:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			c.ip++
-
-			// Store the value in the register on the stack
-			c.stack.Push(c.regs[reg].GetInt())
-
-		case opcode.STACK_POP:
-			// register
-			c.ip++
-			reg := int(c.mem[c.ip])
-
-			// bounds-check our register
-			if int(reg) > len(c.regs) {
-				fmt.Printf("Register %d out of range\n", reg)
-				os.Exit(1)
-			}
-
-			c.ip++
-
-			// Ensure our stack isn't empty
-			if c.stack.Empty() {
-				fmt.Printf("Stack Underflow!\n")
-				os.Exit(1)
-			}
-			// Store the value in the register on the stack
-			val, _ := c.stack.Pop()
-			c.regs[reg].SetInt(val)
-
-		case opcode.STACK_RET:
-			// Ensure our stack isn't empty
-			if c.stack.Empty() {
-				fmt.Printf("Stack Underflow!\n")
-				os.Exit(1)
+//
+// It does not terminate until an `EXIT` instruction is hit, at which point
+// the status the program exited with is returned, or the program faults.
+// Faults are returned as a *Fault.
+func (c *CPU) Run() (int, error) {
+	debug := os.Getenv("DEBUG") != ""
+
+	c.halted = false
+	c.status = 0
+	for !c.halted {
+
+		// Take any pending interrupt, before the next instruction.
+		if c.ie && atomic.LoadUint32(&c.pending) != 0 {
+			err := c.dispatch()
+			if err != nil {
+				return 0, c.annotate(err, c.ip)
 			}
+		}
 
-			// Get the address
-			addr, _ := c.stack.Pop()
-
-			// jump
-			c.ip = addr
-
-		case opcode.STACK_CALL:
-			c.ip++
-
-			addr := c.read2Val()
-
-			// push the current IP onto the stack
-			c.stack.Push(c.ip)
-
-			// jump to the call address
-			c.ip = addr
-
-		case opcode.TRAP_OP:
-			c.ip++
+		in := c.fetch()
+		if debug {
+			op := opcode.NewOpcode(in.Op)
+			debugPrintf("%04X %02X [%s]\n", in.addr, op.Value(), op.String())
+		}
 
-			num := c.read2Val()
+		// Move past the instruction before we execute it, so that
+		// jumps are free to overwrite the IP.
+		c.ip = in.next
 
-			fn := TRAPS[num]
-			if fn != nil {
-				fn(c, num)
-			}
-		default:
-			fmt.Printf("Unrecognized/Unimplemented opcode %02X at IP %04X\n", op.Value(), c.ip)
-			os.Exit(1)
-			c.ip++
+		err := c.checkExec(in.addr, in.Size)
+		if err == nil {
+			err = in.fn(c, in)
+		}
+		if err != nil {
+			return 0, c.annotate(err, in.addr)
 		}
 
-		// Ensure our instruction-pointer wraps around.
-		if c.ip > 0xFFFF {
-			c.ip = 0
+		for _, t := range c.tickers {
+			t.Tick(c)
 		}
 	}
+	return c.status, nil
+}
+
+// annotate records the address at which a fault occurred, and the
+// subroutines which were active, unless it was already recorded.
+func (c *CPU) annotate(err error, addr int) error {
+	if f, ok := err.(*Fault); ok && f.IP < 0 {
+		f.IP = addr
+		f.Trace = c.trace()
+	}
+	return err
 }
@@ -0,0 +1,492 @@
+package cpu
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/skx/go.vm/compiler"
+	"github.com/skx/go.vm/lexer"
+	"github.com/skx/go.vm/opcode"
+)
+
+// compilerFor returns a compiler which has compiled the given source.
+func compilerFor(src string) *compiler.Compiler {
+	e := compiler.New(lexer.New(src))
+	e.Compile()
+	return e
+}
+
+// compile turns the given source into bytecode.
+func compile(t testing.TB, src string) []byte {
+	return compilerFor(src).Output()
+}
+
+// compileFile turns the named example into bytecode.
+func compileFile(t testing.TB, path string) []byte {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err.Error())
+	}
+	return compile(t, string(src))
+}
+
+// silence discards anything written to STDOUT until the returned
+// function is invoked.
+func silence(t testing.TB) func() {
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", os.DevNull, err.Error())
+	}
+
+	orig := os.Stdout
+	os.Stdout = null
+	return func() {
+		os.Stdout = orig
+		null.Close()
+	}
+}
+
+// Test that a simple counting loop leaves the expected state behind.
+func TestLoop(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+        store #1, 0x100
+        store #2, 1
+        store #3, 0
+:loop
+        inc #3
+        sub #1, #1, #2
+        jmpnz loop
+        exit
+`))
+	c.Run()
+
+	if c.regs[1].GetInt() != 0 {
+		t.Errorf("unexpected counter value %d", c.regs[1].GetInt())
+	}
+	if c.regs[3].GetInt() != 0x100 {
+		t.Errorf("unexpected iteration count %d", c.regs[3].GetInt())
+	}
+}
+
+// Test that writing over code which has already been executed is noticed.
+func TestSelfModify(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+:again
+        store #1, 1
+        cmp #2, 1
+        jmpz done
+
+        # Overwrite the value stored by the first instruction.
+        store #2, 1
+        store #3, 2
+        store #4, 2
+        poke #3, #4
+        goto again
+:done
+        exit
+`))
+	c.Run()
+
+	if c.regs[1].GetInt() != 2 {
+		t.Errorf("modified instruction was not re-decoded, got %d", c.regs[1].GetInt())
+	}
+}
+
+// Test that programs can exit with a status.
+func TestExitStatus(t *testing.T) {
+
+	tests := map[string]int{
+		"exit\n":                  0,
+		"exit 3\n":                3,
+		"store #4, 42\nexit #4\n": 42,
+	}
+
+	for src, expected := range tests {
+		c := NewCPU()
+		c.LoadBytes(compile(t, src))
+
+		status, err := c.Run()
+		if err != nil {
+			t.Errorf("unexpected error running %q: %s", src, err.Error())
+		}
+		if status != expected {
+			t.Errorf("expected status %d for %q, got %d", expected, src, status)
+		}
+	}
+}
+
+// Test that every register may be used, and that registers which don't
+// exist are reported rather than crashing the host.
+func TestRegisters(t *testing.T) {
+
+	c := NewCPU()
+	c.LoadBytes(compile(t, "store #15, 7\nadd #15, #15, #15\nexit #15\n"))
+	status, err := c.Run()
+	if err != nil || status != 14 {
+		t.Errorf("expected status 14 using #15, got %d %v", status, err)
+	}
+
+	e := compiler.New(lexer.New("store #1, 3\nstore #40, 4\nadd #0, #1, #40\nexit #0\n"))
+	err = e.SetRegisters(41)
+	if err != nil {
+		t.Fatalf("unexpected error setting registers: %s", err.Error())
+	}
+	e.Compile()
+
+	// The number of registers is recorded in the program's header.
+	c = NewCPU()
+	err = c.LoadBytes(e.Output())
+	if err != nil {
+		t.Fatalf("unexpected error loading: %s", err.Error())
+	}
+	status, err = c.Run()
+	if err != nil || status != 7 {
+		t.Errorf("expected status 7 with 41 registers, got %d %v", status, err)
+	}
+	if len(c.Registers()) != 41 {
+		t.Errorf("expected 41 registers, got %d", len(c.Registers()))
+	}
+
+	// Programs without a header have the default of 16.
+	c.LoadBytes(compile(t, "exit #0\n"))
+	if len(c.Registers()) != 16 {
+		t.Errorf("expected 16 registers, got %d", len(c.Registers()))
+	}
+
+	// A header of zero registers is rejected.
+	data := append([]byte{}, opcode.Magic...)
+	data = append(data, opcode.SectionRegisters, 1, 0, 0, opcode.SectionEnd, 0, 0)
+	if c.LoadBytes(data) == nil {
+		t.Errorf("expected an error loading a program with no registers")
+	}
+
+	for _, n := range []int{0, 256} {
+		if c.SetRegisters(n) == nil {
+			t.Errorf("expected an error setting %d registers", n)
+		}
+		if e.SetRegisters(n) == nil {
+			t.Errorf("expected an error compiling for %d registers", n)
+		}
+	}
+}
+
+// Test that integers wrap around consistently, at each width.
+func TestWidth(t *testing.T) {
+
+	tests := []struct {
+		src      string
+		reg      int
+		expected int
+	}{
+		{"store #1, 0xFFFF\ninc #1\nexit\n", 1, 0},
+		{"store #1, 0\ndec #1\nexit\n", 1, 0xFFFF},
+		{"store #1, 0xFFFF\nstore #2, 2\nadd #0, #1, #2\nexit\n", 0, 1},
+		{"store #1, 1\nstore #2, 2\nsub #0, #1, #2\nexit\n", 0, 0xFFFF},
+		{"store #1, -1\nexit\n", 1, 0xFFFF},
+		{"bits 32\nstore #1, 1\nstore #2, 2\nsub #0, #1, #2\nexit\n", 0, -1},
+		{"bits 32\nstore #1, -5\nstore #2, 3\nmul #0, #1, #2\nexit\n", 0, -15},
+		{"bits 32\nstore #1, 2147483647\ninc #1\nexit\n", 1, -2147483648},
+		{"bits 32\nstore #0, 1\nstore #1, 100000\ncmp #1, 100000\njmpnz done\nstore #0, 0\n:done\nexit\n", 0, 0},
+		{"bits 64\nstore #1, 0x100000000\nadd #0, #1, #1\nexit\n", 0, 0x200000000},
+		{"bits 64\nstore #1, 0x100000000\nmul #0, #1, #1\nexit\n", 0, 0},
+		{"store #1, 5\nadd #0, #1, -1\nexit\n", 0, 4},
+		{"bits 32\nstore #1, 5\nsub #0, #1, 7\nexit\n", 0, -2},
+		{"bits 64\nstore #1, 1\nshl #0, #1, 40\nexit\n", 0, 1 << 40},
+		{"bits 32\nstore #1, 5\nadd #0, #1, 0xFFFF\nexit\n", 0, 0x10004},
+		{"bits 32\nstore #1, 5\nadd #0, #1, -7\nexit\n", 0, -2},
+		{"bits 32\nstore #1, 5\nmul #0, #1, 100000\nexit\n", 0, 500000},
+	}
+
+	for _, test := range tests {
+		c := NewCPU()
+		err := c.LoadBytes(compile(t, test.src))
+		if err != nil {
+			t.Fatalf("failed to load %q: %s", test.src, err.Error())
+		}
+
+		_, err = c.Run()
+		if err != nil {
+			t.Errorf("unexpected error running %q: %s", test.src, err.Error())
+		}
+		if c.regs[test.reg].GetInt() != test.expected {
+			t.Errorf("expected #%d to be %d for %q, got %d", test.reg, test.expected, test.src, c.regs[test.reg].GetInt())
+		}
+	}
+
+	// Immediates which fit in two bytes use the short form.
+	for src, size := range map[string]int{
+		"add #0, #1, 7\n":                      5,
+		"add #0, #1, -7\n":                     5,
+		"bits 32\nadd #0, #1, 7\n":             5,
+		"bits 32\nadd #0, #1, -7\n":            11,
+		"bits 64\nadd #0, #1, 0x10000000000\n": 11,
+	} {
+		_, code, err := opcode.ParseHeader(compile(t, src))
+		if err != nil || len(code) != size {
+			t.Errorf("expected %q to compile to %d bytes, got %d %v", src, size, len(code), err)
+		}
+	}
+
+	// Programs with a corrupt header are rejected.
+	c := NewCPU()
+	if c.LoadBytes([]byte{0xFF, 'G', 'V', 'M', 0x01, 0x01, 0x00, 12, 0x00, 0x00, 0x00}) == nil {
+		t.Errorf("expected an error loading a program with 12-bit integers")
+	}
+}
+
+// Test that invalid programs return faults, rather than terminating.
+func TestFaults(t *testing.T) {
+
+	tests := []struct {
+		src  string
+		kind FaultKind
+		ip   int
+	}{
+		{"store #1, 0\nstore #2, 1\ndiv #3, #2, #1\n", DivideFault, 8},
+		{"store #1, 1\nmod #3, #1, 0\n", DivideFault, 4},
+		{"pop #1\n", StackFault, 0},
+		{"ret\n", StackFault, 0},
+		{"store #1, \"x\"\ninc #1\n", TypeFault, 5},
+		{"store #1, \"x\"\nstring2int #1\n", ConversionFault, 5},
+		{"store #1, \"x\"\nstring2int #1, 16\n", ConversionFault, 5},
+		{"store #1, \"x\"\nstore #2, 1\ncharat #3, #1, #2\n", IndexFault, 9},
+		{"store #1, 1\nstrlen #2, #1\n", TypeFault, 4},
+		{"store #1, 65\nupper #1\n", TypeFault, 4},
+		{"store #1, \"x\"\nstore #2, 0\ngetbyte #3, #1, #2\n", TypeFault, 9},
+		{"store #1, 2\nbytes #2, #1\nsetbyte #2, #1, #1\n", IndexFault, 7},
+		{"map #1\nstore #2, 1\nmapget #3, #1, #2\n", KeyFault, 6},
+		{"map #1\nmapset #1, #1, #1\n", TypeFault, 2},
+		{"store #1, 1\nbytes #2, #1\nmap #3\nappend #2, #3\n", TypeFault, 9},
+		{"store #1, 0xFFFF\npeekw #2, #1\n", MemoryFault, 4},
+		{"store #1, 0xFFFF\npeek #2, #1\npoke #2, #1\nstore #3, 2\nmemcpy #1, #1, #3\n", MemoryFault, 14},
+		{"store #1, \"x\"\nstore #2, 0xFFFF\nstore_mem #1, #2\n", MemoryFault, 9},
+		{"store #1, 2\nstore #2, 0xFFFF\nmemset #1, #1, #2\n", MemoryFault, 8},
+		{"int 0x1234\n", TrapFault, 0},
+		{"int 0xFFFF\n", TrapFault, 0},
+		{"store #1, 101\nexit #1\n", StatusFault, 4},
+		{"store #1, -1\nexit #1\n", StatusFault, 4},
+		{"DB 0x05, 0x00, 0x01\n", StatusFault, 0},
+		{"DB 0xFE\n", OpcodeFault, 0},
+	}
+
+	for _, test := range tests {
+		c := NewCPU()
+		c.LoadBytes(compile(t, test.src))
+
+		_, err := c.Run()
+		f, ok := err.(*Fault)
+		if !ok {
+			t.Errorf("expected a fault running %q, got %v", test.src, err)
+			continue
+		}
+		if f.Kind != test.kind {
+			t.Errorf("expected fault %d running %q, got %d", test.kind, test.src, f.Kind)
+		}
+		if f.IP != test.ip {
+			t.Errorf("expected fault at %04X running %q, got %04X", test.ip, test.src, f.IP)
+		}
+	}
+}
+
+// Test that the system policy is applied to commands.
+func TestSystemPolicy(t *testing.T) {
+
+	tests := []struct {
+		policy SystemPolicy
+		src    string
+		fault  bool
+	}{
+		{SystemPolicy{}, `store #1, "true"`, true},
+		{SystemPolicy{Enabled: true}, `store #1, "true"`, false},
+		{SystemPolicy{Enabled: true, Allow: []string{"true"}}, `store #1, "true"`, false},
+		{SystemPolicy{Enabled: true, Allow: []string{"true"}}, `store #1, "false"`, true},
+		{SystemPolicy{Enabled: true}, `store #1, "false"`, false},
+		{SystemPolicy{Enabled: true, Timeout: 10 * time.Millisecond}, `store #1, "sleep 5"`, true},
+		{SystemPolicy{Enabled: true, MaxOutput: 4}, `store #1, "echo hello"`, true},
+		{SystemPolicy{Enabled: true, MaxOutput: 6}, `store #1, "echo hello"`, false},
+	}
+
+	restore := silence(t)
+	defer restore()
+
+	for _, test := range tests {
+		c := NewCPU()
+		c.SetSystemPolicy(test.policy)
+		c.LoadBytes(compile(t, test.src+"\nsystem #1\n"))
+
+		_, err := c.Run()
+		f, ok := err.(*Fault)
+		if test.fault && (!ok || f.Kind != SystemFault) {
+			t.Errorf("expected a system fault running %q with %+v, got %v", test.src, test.policy, err)
+		}
+		if !test.fault && err != nil {
+			t.Errorf("unexpected error running %q with %+v: %s", test.src, test.policy, err.Error())
+		}
+	}
+}
+
+// Test that a command which leaves a process running in the background,
+// holding its output open, is still killed when it times out.
+func TestSystemTimeoutBackground(t *testing.T) {
+	c := NewCPU()
+	c.SetSystemPolicy(SystemPolicy{Enabled: true, Timeout: 100 * time.Millisecond})
+	c.LoadBytes(compile(t, "store #1, \"/bin/sh -c \\\"sleep 5 & echo hi\\\"\"\nsystem #1\n"))
+
+	restore := silence(t)
+	defer restore()
+
+	start := time.Now()
+	_, err := c.Run()
+	if f, ok := err.(*Fault); !ok || f.Kind != SystemFault {
+		t.Errorf("expected a system fault, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("command wasn't killed at the timeout, took %s", time.Since(start))
+	}
+}
+
+// Test that the results of commands can be captured.
+func TestSystemCapture(t *testing.T) {
+	c := NewCPU()
+	c.SetSystemPolicy(SystemPolicy{Enabled: true})
+	c.LoadBytes(compile(t, `
+        store #1, "tr a-z A-Z"
+        store #5, "hello"
+        system #1, #2, #3, #4, #5
+
+        store #6, "ls /does/not/exist"
+        system #6, #7, #8, #9
+
+        system #1, #10, #11, _, #5
+        exit
+`))
+
+	_, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if c.regs[2].GetString() != "HELLO" {
+		t.Errorf("unexpected output %q", c.regs[2].GetString())
+	}
+	if c.regs[3].GetInt() != 0 || c.regs[4].GetString() != "" {
+		t.Errorf("unexpected result %d %q", c.regs[3].GetInt(), c.regs[4].GetString())
+	}
+	if c.regs[7].GetString() != "" || c.regs[8].GetInt() == 0 || c.regs[9].GetString() == "" {
+		t.Errorf("unexpected result %q %d %q", c.regs[7].GetString(), c.regs[8].GetInt(), c.regs[9].GetString())
+	}
+	if c.regs[10].GetString() != "HELLO" || c.regs[11].GetInt() != 0 {
+		t.Errorf("unexpected result %q %d without stderr", c.regs[10].GetString(), c.regs[11].GetInt())
+	}
+}
+
+// Test that the resource limits are enforced.
+func TestLimits(t *testing.T) {
+
+	tests := []struct {
+		limits Limits
+		src    string
+		kind   FaultKind
+	}{
+		{Limits{MaxString: 16}, "store #1, \"abcd\"\n:again\nconcat #1, #1, #1\njmp again\n", StringLengthFault},
+		{Limits{MaxStrings: 10}, "store #1, \"abcd\"\nstore #2, #1\nstore #3, #1\n", StringMemoryFault},
+		{Limits{MaxStack: 8}, "store #1, 1\n:again\npush #1\njmp again\n", StackOverflowFault},
+		{Limits{MaxStack: 8}, "nop\n:again\ncall again\n", StackOverflowFault},
+		{Limits{MaxStrings: 1000}, "store #1, \"abcd\"\n:again\npush #1\njmp again\n", StringMemoryFault},
+		{Limits{MaxStrings: 1000}, "store #0, 100\nbytes #1, #0\n:again\npush #1\njmp again\n", StringMemoryFault},
+		{Limits{MaxStrings: 12}, "store #1, \"abcdef\"\npush #1\npop #1\npush #1\n", 0},
+		{Limits{MaxStrings: 12}, "store #1, \"abcdef\"\npush #1\nstore #2, \"abcdef\"\n", StringMemoryFault},
+		{Limits{MaxStrings: 12}, "store #1, \"abcdef\"\nstore #2, \",\"\nsplit #3, #1, #2\n", StringMemoryFault},
+		{Limits{MaxStrings: 12}, "store #1, \"abcdef\"\nenter 2\nsave #1, 0\nsave #1, 0\nsave #1, 1\n", StringMemoryFault},
+	}
+
+	for _, test := range tests {
+		c := NewCPU()
+		c.SetLimits(test.limits)
+		c.LoadBytes(compile(t, test.src))
+
+		_, err := c.Run()
+		if test.kind == 0 {
+			if err != nil {
+				t.Errorf("unexpected error running %q: %s", test.src, err.Error())
+			}
+			continue
+		}
+		f, ok := err.(*Fault)
+		if !ok || f.Kind != test.kind {
+			t.Errorf("expected fault %d running %q, got %v", test.kind, test.src, err)
+		}
+	}
+
+	// Replacing a string doesn't count against the limit.
+	c := NewCPU()
+	c.SetLimits(Limits{MaxStrings: 10})
+	c.LoadBytes(compile(t, "store #1, \"abcdefgh\"\nstore #1, \"12345678\"\n"))
+	if _, err := c.Run(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
+
+// BenchmarkLoopExample runs examples/loop.in.
+func BenchmarkLoopExample(b *testing.B) {
+	prog := compileFile(b, "../examples/loop.in")
+
+	restore := silence(b)
+	defer restore()
+
+	c := NewCPU()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.LoadBytes(prog)
+		c.Run()
+	}
+}
+
+// BenchmarkCountdown runs a tight loop with no output at all.
+func BenchmarkCountdown(b *testing.B) {
+	prog := compile(b, `
+        store #1, 0xFFFF
+        store #2, 1
+:loop
+        sub #1, #1, #2
+        jmpnz loop
+        exit
+`)
+
+	c := NewCPU()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.LoadBytes(prog)
+		c.Run()
+	}
+}
+
+// BenchmarkStrings exercises string-handling in a loop.
+func BenchmarkStrings(b *testing.B) {
+	prog := compile(b, `
+        store #1, 0x1000
+        store #2, 1
+:loop
+        store #3, "This is a string of moderate length"
+        cmp #3, "This is a string of moderate length"
+        sub #1, #1, #2
+        jmpnz loop
+        exit
+`)
+
+	c := NewCPU()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.LoadBytes(prog)
+		c.Run()
+	}
+
+	// The comparison leaves the Z-flag set, so ensure the subtraction
+	// cleared it and every iteration ran.
+	if c.regs[1].GetInt() != 0 {
+		b.Fatalf("loop ended early, with %d iterations remaining", c.regs[1].GetInt())
+	}
+}
@@ -0,0 +1,81 @@
+// This file contains the instruction-cache the CPU uses.
+//
+// Each instruction is decoded the first time the IP reaches it, and the
+// decoded form is kept until the memory it was decoded from is written
+// to, at which point the whole cache is discarded.
+
+package cpu
+
+import (
+	"github.com/skx/go.vm/opcode"
+)
+
+// handler is the signature of the function which implements an opcode.
+type handler func(c *CPU, in *instruction) error
+
+// instruction is a decoded instruction, along with the handler which
+// will execute it.
+type instruction struct {
+	opcode.Instruction
+
+	// The address this instruction was decoded from.
+	addr int
+
+	// The address of the instruction which follows this one.
+	next int
+
+	// The function which implements this instruction.
+	fn handler
+}
+
+// fetch returns the decoded instruction at the current IP, decoding it
+// if it hasn't been seen before.
+//
+// The IP is always within RAM, as jumps outside it fault and the address
+// of the next instruction wraps around to the start.
+func (c *CPU) fetch() *instruction {
+	if i := c.index[c.ip]; i != 0 {
+		return &c.code[i-1]
+	}
+
+	in := instruction{addr: c.ip}
+
+	var ok bool
+	in.Instruction, ok = opcode.Decode(c.mem, c.ip)
+	in.fn = handlers[in.Op]
+	if !ok || in.fn == nil {
+		in.fn = opUnknown
+	}
+	in.next = (c.ip + in.Size) % len(c.mem)
+
+	// Record which bytes this instruction was decoded from, so that
+	// we notice if they're changed.
+	for i := 0; i < in.Size; i++ {
+		c.covered[(c.ip+i)%len(c.mem)] = true
+	}
+
+	c.code = append(c.code, in)
+	c.index[c.ip] = int32(len(c.code))
+	return &c.code[len(c.code)-1]
+}
+
+// flush discards all decoded instructions.
+func (c *CPU) flush() {
+	for n := range c.code {
+		in := &c.code[n]
+		c.index[in.addr] = 0
+		for i := 0; i < in.Size; i++ {
+			c.covered[(in.addr+i)%len(c.mem)] = false
+		}
+	}
+	c.code = c.code[:0]
+}
+
+// writeMem stores a byte in RAM, discarding any decoded instructions if
+// that byte was part of one.
+func (c *CPU) writeMem(addr int, val byte) {
+	c.mem[addr] = val
+	if c.covered[addr] {
+		c.flush()
+	}
+}
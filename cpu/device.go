@@ -0,0 +1,168 @@
+// This file contains the devices which may be mapped into memory, so that
+// a program may drive them via `peek`, `poke` and the other memory
+// instructions, rather than via traps.
+//
+// A device is attached to a range of addresses, and every read or write
+// of an address within it calls the device instead of accessing RAM.
+// Devices may not be executed, and they are still subject to the
+// protection of memory - see protect.go.
+
+package cpu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Device is a peripheral which is mapped into memory.
+//
+// Each method is given the offset of the address being accessed, from
+// the start of the device.  If either fails it should return an error,
+// which will be reported as a fault.
+type Device interface {
+	// Read returns the byte at the given offset.
+	Read(offset int) (byte, error)
+
+	// Write stores a byte at the given offset.
+	Write(offset int, val byte) error
+}
+
+// mapping records the range of addresses a device is attached to.
+type mapping struct {
+	start  int
+	end    int
+	device Device
+}
+
+// Attach maps a device into the given number of addresses, beginning at
+// start.  The addresses must lie within RAM, and not overlap those of
+// another device.
+func (c *CPU) Attach(start int, size int, d Device) error {
+	end := start + size
+	if start < 0 || size < 1 || end > len(c.mem) {
+		return fmt.Errorf("device at %04X-%04X is outside RAM", start, end)
+	}
+	for _, m := range c.devices {
+		if start < m.end && m.start < end {
+			return fmt.Errorf("device at %04X-%04X overlaps another at %04X-%04X", start, end, m.start, m.end)
+		}
+	}
+	c.devices = append(c.devices, mapping{start: start, end: end, device: d})
+	if t, ok := d.(Ticker); ok {
+		c.tickers = append(c.tickers, t)
+	}
+	return nil
+}
+
+// Detach removes a device from memory, revealing the RAM beneath it.
+func (c *CPU) Detach(d Device) {
+	for i, m := range c.devices {
+		if m.device == d {
+			c.devices = append(c.devices[:i], c.devices[i+1:]...)
+			break
+		}
+	}
+	if dt, ok := d.(Ticker); ok {
+		for i, t := range c.tickers {
+			if t == dt {
+				c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// device returns the mapping of the device at the given address, if
+// there is one.
+func (c *CPU) device(addr int) *mapping {
+	for i := range c.devices {
+		if addr >= c.devices[i].start && addr < c.devices[i].end {
+			return &c.devices[i]
+		}
+	}
+	return nil
+}
+
+// deviceError converts an error returned by a device into a fault, unless
+// the device was more specific.
+func deviceError(err error) error {
+	if _, ok := err.(*Fault); ok || err == nil {
+		return err
+	}
+	return fault(DeviceFault, "%s", err.Error())
+}
+
+// readByte returns the byte at the given address, which must lie within
+// RAM, from a device if one is attached there.
+func (c *CPU) readByte(addr int) (byte, error) {
+	if m := c.device(addr); m != nil {
+		b, err := m.device.Read(addr - m.start)
+		return b, deviceError(err)
+	}
+	return c.mem[addr], nil
+}
+
+// writeByte stores a byte at the given address, which must lie within
+// RAM, in a device if one is attached there.
+func (c *CPU) writeByte(addr int, val byte) error {
+	if m := c.device(addr); m != nil {
+		return deviceError(m.device.Write(addr-m.start, val))
+	}
+	c.writeMem(addr, val)
+	return nil
+}
+
+//
+// Devices now follow
+//
+
+// ConsoleSize is the number of addresses a Console occupies.
+const ConsoleSize = 2
+
+// Console is a device which reads and writes characters, as a serial port
+// would.
+//
+// Writing to its first address outputs a byte, and reading it inputs one,
+// or zero if the input has ended.  Its second address reads as one once
+// the input has ended, and zero before.
+type Console struct {
+	in  *bufio.Reader
+	out io.Writer
+	eof bool
+}
+
+// NewConsole returns a console which reads from in, and writes to out.
+func NewConsole(in io.Reader, out io.Writer) *Console {
+	r, ok := in.(*bufio.Reader)
+	if !ok {
+		r = bufio.NewReader(in)
+	}
+	return &Console{in: r, out: out}
+}
+
+// Read inputs a byte, or reports whether the input has ended.
+func (d *Console) Read(offset int) (byte, error) {
+	if offset == 1 {
+		if d.eof {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	b, err := d.in.ReadByte()
+	if err == io.EOF {
+		d.eof = true
+		return 0, nil
+	}
+	return b, err
+}
+
+// Write outputs a byte, writes to the second address are ignored.
+func (d *Console) Write(offset int, val byte) error {
+	if offset == 1 {
+		return nil
+	}
+	_, err := d.out.Write([]byte{val})
+	return err
+}
@@ -0,0 +1,102 @@
+package cpu
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// counter is a device which counts the reads and writes made of it.
+type counter struct {
+	reads  int
+	writes []byte
+}
+
+func (d *counter) Read(offset int) (byte, error) {
+	d.reads++
+	if offset == 3 {
+		return 0, fmt.Errorf("offset %d is broken", offset)
+	}
+	return byte(offset + 'a'), nil
+}
+
+func (d *counter) Write(offset int, val byte) error {
+	d.writes = append(d.writes, val)
+	return nil
+}
+
+// Test that the memory instructions access devices rather than RAM.
+func TestDevice(t *testing.T) {
+	d := &counter{}
+
+	c := NewCPU()
+	if err := c.Attach(0x8000, 3, d); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	c.LoadBytes(compile(t, `
+        store #1, 0x8000
+        peek #2, #1
+        store #3, 3
+        load_mem #4, #1, #3
+        store #5, "xy"
+        store_mem #5, #1
+        exit
+`))
+	if _, err := c.Run(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if v, _ := c.getInt(2); v != 'a' {
+		t.Errorf("peek read %d", v)
+	}
+	if s, _ := c.getString(4); s != "abc" {
+		t.Errorf("load_mem read %q", s)
+	}
+	if d.reads != 4 || string(d.writes) != "xy\x00" {
+		t.Errorf("device saw %d reads, and writes of %q", d.reads, d.writes)
+	}
+	if c.mem[0x8000] != 0 {
+		t.Errorf("a write reached the RAM beneath a device")
+	}
+
+	// Devices may not be executed, and their errors are faults.
+	c.Detach(d)
+	c.Attach(0x8000, 4, d)
+	tests := map[string]FaultKind{
+		"goto 0x8000\n":                   ProtectionFault,
+		"store #1, 0x8003\npeek #2, #1\n": DeviceFault,
+	}
+	for src, kind := range tests {
+		c.LoadBytes(compile(t, src))
+
+		_, err := c.Run()
+		if f, ok := err.(*Fault); !ok || f.Kind != kind {
+			t.Errorf("expected fault %d running %q, got %v", kind, src, err)
+		}
+	}
+
+	// Devices must not overlap, and must lie within RAM.
+	if c.Attach(0x8002, 4, &counter{}) == nil || c.Attach(DefaultMemory-1, 2, &counter{}) == nil {
+		t.Errorf("expected an invalid device to be rejected")
+	}
+}
+
+// Test that the console reads and writes characters.
+func TestConsole(t *testing.T) {
+	var out bytes.Buffer
+	d := NewConsole(strings.NewReader("hi"), &out)
+
+	var in []byte
+	for {
+		if end, _ := d.Read(1); end == 1 {
+			break
+		}
+		b, _ := d.Read(0)
+		d.Write(0, b)
+		in = append(in, b)
+	}
+	if string(in) != "hi\x00" || out.String() != "hi\x00" {
+		t.Errorf("console read %q, and wrote %q", in, out.String())
+	}
+}
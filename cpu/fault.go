@@ -0,0 +1,110 @@
+// This file contains the errors the CPU raises when a program does
+// something invalid.
+
+package cpu
+
+import "fmt"
+
+// FaultKind describes the class of a fault.
+type FaultKind int
+
+const (
+	// RegisterFault is raised when a register doesn't exist.
+	RegisterFault FaultKind = iota + 1
+
+	// TypeFault is raised when a register holds the wrong type of value.
+	TypeFault
+
+	// DivideFault is raised when dividing by zero.
+	DivideFault
+
+	// StackFault is raised when popping from an empty stack.
+	StackFault
+
+	// OpcodeFault is raised when an unknown opcode is executed.
+	OpcodeFault
+
+	// TrapFault is raised when a trap is undefined, or fails.
+	TrapFault
+
+	// ConversionFault is raised when a string cannot be converted
+	// to an integer.
+	ConversionFault
+
+	// SystemFault is raised when a command is denied by the system
+	// policy, or cannot be run.
+	SystemFault
+
+	// StringLengthFault is raised when a string would exceed the maximum
+	// length.
+	StringLengthFault
+
+	// StringMemoryFault is raised when the strings held in registers,
+	// and on the stack, would exceed the maximum size.
+	StringMemoryFault
+
+	// StackOverflowFault is raised when the stack would exceed the
+	// maximum depth.
+	StackOverflowFault
+
+	// IndexFault is raised when a position is outside a string, or a
+	// byte-array.
+	IndexFault
+
+	// KeyFault is raised when a key isn't present in a map.
+	KeyFault
+
+	// MemoryFault is raised when an address is outside RAM.
+	MemoryFault
+
+	// ProtectionFault is raised when memory is accessed in a way its
+	// permissions don't allow.
+	ProtectionFault
+
+	// DeviceFault is raised when a device mapped into memory fails.
+	DeviceFault
+
+	// StatusFault is raised when a program exits with a status outside
+	// the range 0 to opcode.MaxStatus.
+	StatusFault
+
+	// ValueFault is raised when an integer is too large to be stored in
+	// a byte, or word, of RAM.
+	ValueFault
+)
+
+// Fault is the error returned by Run when the program faults.
+type Fault struct {
+	// Kind is the class of fault.
+	Kind FaultKind
+
+	// IP is the address of the instruction which faulted.
+	IP int
+
+	// Message describes the fault.
+	Message string
+
+	// Trace describes the subroutines which were active when the fault
+	// occurred, starting with the innermost.
+	Trace []string
+}
+
+// fault creates a new Fault, the IP is filled in by Run.
+func fault(kind FaultKind, format string, args ...interface{}) error {
+	return &Fault{Kind: kind, IP: -1, Message: fmt.Sprintf(format, args...)}
+}
+
+// Error returns a description of the fault, including the address at
+// which it occurred.
+func (f *Fault) Error() string {
+	if f.IP < 0 {
+		return f.Message
+	}
+	return fmt.Sprintf("%s at IP %04X", f.Message, f.IP)
+}
+
+// ExitCode returns the status a process should exit with, following the
+// given fault.  Each kind of fault has a distinct status, starting at 101.
+func (f *Fault) ExitCode() int {
+	return 100 + int(f.Kind)
+}
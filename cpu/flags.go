@@ -0,0 +1,223 @@
+// This file contains the CPU flags, and the arithmetic which sets them.
+//
+// Each arithmetic operation, and `cmp`, sets all four flags from its
+// result.  The conditional jumps then test them:
+//
+//   jmpz/jmpnz    - the result was zero, or not.
+//   jc/jnc        - the result carried out of the top bit, or borrowed.
+//   jl/jge        - the first value was less than the second, or not.
+//   jg/jle        - the first value was greater than the second, or not.
+//
+// By default integers are unsigned, so "less than" means a borrow took
+// place.  When a program selects 32 or 64-bit integers they are signed
+// instead, so "less than" means the sign of the result differs from the
+// overflow flag - as on most real CPUs.
+//
+// The operations are exported so that programs generated by `go.vm togo`
+// behave identically.
+
+package cpu
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Flags holds the CPU flags.
+type Flags struct {
+	// Zero-flag
+	z bool
+
+	// Carry-flag
+	c bool
+
+	// Negative-flag, a copy of the top bit of the result.
+	n bool
+
+	// Overflow-flag, set if a signed result didn't fit.
+	v bool
+}
+
+// Zero returns true if the zero-flag is set.
+func (f Flags) Zero() bool {
+	return f.z
+}
+
+// SetZero sets the zero-flag, leaving the others alone.
+func (f *Flags) SetZero(z bool) {
+	f.z = z
+}
+
+// Carry returns true if the carry-flag is set.
+func (f Flags) Carry() bool {
+	return f.c
+}
+
+// Less returns true if the last comparison found the first value to be
+// less than the second, for integers of the given width.
+func (f Flags) Less(w Width) bool {
+	if w == Width16 {
+		return f.c
+	}
+	return f.n != f.v
+}
+
+// top returns the bits of an integer moved to the top of a 64-bit word,
+// so that the carry and sign of every width are found in the same place.
+func (w Width) top(v int) uint64 {
+	return uint64(v) << (64 - uint(w))
+}
+
+// unsigned returns the bits of an integer as an unsigned value.
+func (w Width) unsigned(v int) uint64 {
+	return uint64(v) & (math.MaxUint64 >> (64 - uint(w)))
+}
+
+// signed returns the bits of an integer as a signed value.
+func (w Width) signed(v int) int64 {
+	return int64(w.top(v)) >> (64 - uint(w))
+}
+
+// result returns the zero and negative flags for the given result.
+func (w Width) result(v int) Flags {
+	t := w.top(v)
+	return Flags{z: t == 0, n: int64(t) < 0}
+}
+
+// Add returns the sum of two integers, and the flags it sets.
+func (w Width) Add(a int, b int) (int, Flags) {
+	x, y := w.top(a), w.top(b)
+	sum, carry := bits.Add64(x, y, 0)
+
+	f := w.result(a + b)
+	f.c = carry != 0
+	f.v = int64((x^sum)&(y^sum)) < 0
+	return w.Wrap(a + b), f
+}
+
+// Sub returns the difference of two integers, and the flags it sets.  The
+// carry-flag is set if a borrow took place.
+func (w Width) Sub(a int, b int) (int, Flags) {
+	x, y := w.top(a), w.top(b)
+	diff, borrow := bits.Sub64(x, y, 0)
+
+	f := w.result(a - b)
+	f.c = borrow != 0
+	f.v = int64((x^y)&(x^diff)) < 0
+	return w.Wrap(a - b), f
+}
+
+// Mul returns the product of two integers, and the flags it sets.  The
+// carry-flag is set if the unsigned product didn't fit, and the overflow
+// flag if the signed product didn't.
+func (w Width) Mul(a int, b int) (int, Flags) {
+	f := w.result(a * b)
+
+	hi, lo := bits.Mul64(w.unsigned(a), w.unsigned(b))
+	f.c = hi != 0 || lo != w.unsigned(int(lo))
+
+	x, y := w.signed(a), w.signed(b)
+	p := x * y
+	if x != 0 && (p/x != y || (x == -1 && y == math.MinInt64)) {
+		f.v = true
+	} else {
+		f.v = p != w.signed(int(p))
+	}
+	return w.Wrap(a * b), f
+}
+
+// Div returns the quotient of two integers, and the flags it sets.  The
+// divisor must not be zero.
+func (w Width) Div(a int, b int) (int, Flags) {
+	x, y := w.signed(a), w.signed(b)
+	if w == Width16 {
+		x, y = int64(w.unsigned(a)), int64(w.unsigned(b))
+	}
+
+	f := w.result(int(x / y))
+	f.v = x == math.MinInt64>>(64-uint(w)) && y == -1
+	return w.Wrap(int(x / y)), f
+}
+
+// Logic returns the result of a bitwise operation, wrapped to the width,
+// and the flags it sets.  The carry and overflow flags are cleared.
+func (w Width) Logic(v int) (int, Flags) {
+	return w.Wrap(v), w.result(v)
+}
+
+// Mod returns the remainder of dividing two integers, and the flags it
+// sets.  The divisor must not be zero.
+func (w Width) Mod(a int, b int) (int, Flags) {
+	x, y := w.signed(a), w.signed(b)
+	if w == Width16 {
+		x, y = int64(w.unsigned(a)), int64(w.unsigned(b))
+	}
+	return w.Logic(int(x % y))
+}
+
+// Shl returns an integer shifted left, and the flags it sets.  The
+// carry-flag holds the last bit shifted out.
+func (w Width) Shl(a int, b int) (int, Flags) {
+	u, k := w.unsigned(a), w.unsigned(b)
+
+	v, f := w.Logic(int(u << k))
+	f.c = k != 0 && k <= uint64(w) && (u>>(uint64(w)-k))&1 != 0
+	return v, f
+}
+
+// Shr returns an integer shifted right, with zeros shifted in, and the
+// flags it sets.  The carry-flag holds the last bit shifted out.
+func (w Width) Shr(a int, b int) (int, Flags) {
+	u, k := w.unsigned(a), w.unsigned(b)
+
+	v, f := w.Logic(int(u >> k))
+	f.c = k != 0 && k <= uint64(w) && (u>>(k-1))&1 != 0
+	return v, f
+}
+
+// Sar returns an integer shifted right, with copies of its sign shifted
+// in, and the flags it sets.  The carry-flag holds the last bit shifted
+// out.
+func (w Width) Sar(a int, b int) (int, Flags) {
+	s, k := w.signed(a), w.unsigned(b)
+	if k > 64 {
+		k = 64
+	}
+
+	v, f := w.Logic(int(s >> k))
+	f.c = k != 0 && (s>>(k-1))&1 != 0
+	return v, f
+}
+
+// Rol returns an integer rotated left, and the flags it sets.  The
+// carry-flag holds the bit which was rotated into the bottom.
+func (w Width) Rol(a int, b int) (int, Flags) {
+	u, k := w.unsigned(a), w.unsigned(b)%uint64(w)
+
+	r := w.unsigned(int(u<<k | u>>(uint64(w)-k)))
+	v, f := w.Logic(int(r))
+	f.c = w.unsigned(b) != 0 && r&1 != 0
+	return v, f
+}
+
+// Ror returns an integer rotated right, and the flags it sets.  The
+// carry-flag holds the bit which was rotated into the top.
+func (w Width) Ror(a int, b int) (int, Flags) {
+	u, k := w.unsigned(a), w.unsigned(b)%uint64(w)
+
+	r := w.unsigned(int(u>>k | u<<(uint64(w)-k)))
+	v, f := w.Logic(int(r))
+	f.c = w.unsigned(b) != 0 && f.n
+	return v, f
+}
+
+// Not returns an integer with its bits inverted, and the flags it sets.
+func (w Width) Not(a int) (int, Flags) {
+	return w.Logic(^a)
+}
+
+// Neg returns an integer negated, and the flags it sets.  The carry-flag
+// is set unless the integer was zero.
+func (w Width) Neg(a int) (int, Flags) {
+	return w.Sub(0, a)
+}
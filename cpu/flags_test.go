@@ -0,0 +1,109 @@
+package cpu
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Test the flags set by arithmetic, at each width.
+func TestArithmeticFlags(t *testing.T) {
+
+	add := func(w Width, a, b int) (int, Flags) { return w.Add(a, b) }
+	sub := func(w Width, a, b int) (int, Flags) { return w.Sub(a, b) }
+	mul := func(w Width, a, b int) (int, Flags) { return w.Mul(a, b) }
+
+	tests := []struct {
+		fn     func(w Width, a, b int) (int, Flags)
+		w      Width
+		a, b   int
+		result int
+		flags  Flags
+	}{
+		{add, Width16, 1, 2, 3, Flags{}},
+		{add, Width16, 0xFFFF, 1, 0, Flags{z: true, c: true}},
+		{add, Width16, 0x7FFF, 1, 0x8000, Flags{n: true, v: true}},
+		{sub, Width16, 2, 2, 0, Flags{z: true}},
+		{sub, Width16, 1, 2, 0xFFFF, Flags{c: true, n: true}},
+		{mul, Width16, 0x100, 0x100, 0, Flags{z: true, c: true, v: true}},
+		{add, Width32, 0x7FFFFFFF, 1, -0x80000000, Flags{n: true, v: true}},
+		{add, Width32, -1, 1, 0, Flags{z: true, c: true}},
+		{sub, Width32, -5, 3, -8, Flags{n: true}},
+		{sub, Width32, -0x80000000, 1, 0x7FFFFFFF, Flags{v: true}},
+		{mul, Width32, -3, 4, -12, Flags{n: true, c: true}},
+		{add, Width64, -1, -1, -2, Flags{n: true, c: true}},
+		{sub, Width64, 3, 5, -2, Flags{n: true, c: true}},
+		{Width.Mod, Width16, 17, 5, 2, Flags{}},
+		{Width.Mod, Width32, -17, 5, -2, Flags{n: true}},
+		{Width.Shl, Width16, 0x8001, 1, 2, Flags{c: true}},
+		{Width.Shl, Width16, 1, 16, 0, Flags{z: true, c: true}},
+		{Width.Shl, Width16, 1, 17, 0, Flags{z: true}},
+		{Width.Shr, Width16, 0x8001, 1, 0x4000, Flags{c: true}},
+		{Width.Shr, Width32, -1, 28, 0xF, Flags{c: true}},
+		{Width.Sar, Width16, 0x8000, 3, 0xF000, Flags{n: true}},
+		{Width.Sar, Width32, -16, 2, -4, Flags{n: true}},
+		{Width.Rol, Width16, 0x8001, 1, 3, Flags{c: true}},
+		{Width.Rol, Width32, 1, 33, 2, Flags{}},
+		{Width.Ror, Width16, 0x8001, 1, 0xC000, Flags{c: true, n: true}},
+		{Width.Ror, Width64, 1, 1, -0x8000000000000000, Flags{c: true, n: true}},
+	}
+
+	for _, test := range tests {
+		result, flags := test.fn(test.w, test.a, test.b)
+		if result != test.result {
+			t.Errorf("%d-bit %d, %d gave %d, expected %d", test.w, test.a, test.b, result, test.result)
+		}
+		if flags != test.flags {
+			t.Errorf("%d-bit %d, %d gave flags %+v, expected %+v", test.w, test.a, test.b, flags, test.flags)
+		}
+	}
+}
+
+// Test that the conditional jumps compare values correctly, whether they
+// are unsigned or signed.
+func TestConditionalJumps(t *testing.T) {
+
+	tests := []struct {
+		bits  string
+		a, b  int
+		taken string
+	}{
+		{"16", 1, 2, "jl jle jc jmpnz"},
+		{"16", 2, 2, "jle jge jnc jmpz"},
+		{"16", 3, 2, "jg jge jnc jmpnz"},
+		{"16", 1, 0xFFFF, "jl jle jc jmpnz"},
+		{"32", -1, 2, "jl jle jnc jmpnz"},
+		{"32", 2, -1, "jg jge jc jmpnz"},
+		{"64", -5, -5, "jle jge jnc jmpz"},
+	}
+
+	for _, test := range tests {
+		for _, jump := range []string{"jl", "jg", "jle", "jge", "jc", "jnc", "jmpz", "jmpnz"} {
+			src := "bits " + test.bits + "\n" +
+				"store #1, " + strconv.Itoa(test.a) + "\n" +
+				"store #2, " + strconv.Itoa(test.b) + "\n" +
+				"cmp #1, #2\n" +
+				jump + " yes\n" +
+				"exit 0\n" +
+				":yes\n" +
+				"exit 1\n"
+
+			c := NewCPU()
+			c.LoadBytes(compile(t, src))
+			status, err := c.Run()
+			if err != nil {
+				t.Fatalf("unexpected error running %q: %s", src, err.Error())
+			}
+
+			expected := 0
+			for _, taken := range strings.Fields(test.taken) {
+				if taken == jump {
+					expected = 1
+				}
+			}
+			if status != expected {
+				t.Errorf("%s-bit %d vs %d: %s taken=%d, expected %d", test.bits, test.a, test.b, jump, status, expected)
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// This file contains the formatting of integers, as used by `print_int`
+// when it is given a format, and by the sprintf trap.
+//
+// Decimal integers are printed as they're held, so they are unsigned with
+// 16-bit integers and signed otherwise.  The other bases always print the
+// bits of the integer, so -1 is "ffffffff" with 32-bit integers.
+
+package cpu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// Format returns an integer formatted as the given encoded format
+// describes, see opcode/format.go.
+//
+// The method is exported so that programs generated by `go.vm togo`
+// behave identically.
+func (w Width) Format(v int, format int) (string, error) {
+	verb, width, zero, ok := opcode.DecodeFormat(format)
+	if !ok {
+		return "", fault(OpcodeFault, "Invalid integer format 0x%04X", format)
+	}
+
+	pad := "%*"
+	if zero {
+		pad = "%0*"
+	}
+	if verb == 'd' {
+		return fmt.Sprintf(pad+"d", width, w.Wrap(v)), nil
+	}
+	return fmt.Sprintf(pad+string(verb), width, w.unsigned(v)), nil
+}
+
+// sprintf formats the given template, as the sprintf trap does.
+//
+// Each placeholder consumes the next register, starting with #1.  `%s`
+// inserts a string and `%c` the character whose value is an integer,
+// while integers may be inserted with any format print_int accepts, such
+// as `%d` or `%04x`.  `%%` inserts a single `%`.
+func (c *CPU) sprintf(template string) (string, error) {
+	var out strings.Builder
+	reg := 1
+
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			out.WriteByte(template[i])
+			continue
+		}
+
+		// Find the verb which ends the placeholder.
+		end := i + 1
+		for end < len(template) && template[end] >= '0' && template[end] <= '9' {
+			end++
+		}
+		if end >= len(template) {
+			return "", fmt.Errorf("unterminated placeholder in %q", template)
+		}
+		spec := template[i+1 : end+1]
+		i = end
+
+		switch spec {
+		case "%":
+			out.WriteByte('%')
+			continue
+		case "s":
+			s, err := c.getString(reg)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(s)
+		case "c":
+			v, err := c.getInt(reg)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(Chr(v))
+		default:
+			format, err := opcode.ParseFormat(spec)
+			if err != nil {
+				return "", err
+			}
+			v, err := c.getInt(reg)
+			if err != nil {
+				return "", err
+			}
+			s, _ := c.width.Format(v, format)
+			out.WriteString(s)
+		}
+		reg++
+	}
+	return out.String(), nil
+}
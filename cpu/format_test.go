@@ -0,0 +1,86 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// Test that integers are formatted in each base, at each width.
+func TestFormat(t *testing.T) {
+
+	tests := []struct {
+		w      Width
+		v      int
+		spec   string
+		result string
+	}{
+		{Width16, 1234, "d", "1234"},
+		{Width16, 0xFFFF, "d", "65535"},
+		{Width16, 255, "04X", "00FF"},
+		{Width16, 5, "b", "101"},
+		{Width16, 8, "4o", "  10"},
+		{Width32, -1, "d", "-1"},
+		{Width32, -1, "x", "ffffffff"},
+		{Width32, -5, "05d", "-0005"},
+		{Width64, -1, "X", "FFFFFFFFFFFFFFFF"},
+	}
+
+	for _, test := range tests {
+		format, err := opcode.ParseFormat(test.spec)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %s", test.spec, err.Error())
+		}
+		result, err := test.w.Format(test.v, format)
+		if err != nil {
+			t.Fatalf("unexpected error formatting %q: %s", test.spec, err.Error())
+		}
+		if result != test.result {
+			t.Errorf("%d-bit %d as %q gave %q, expected %q", test.w, test.v, test.spec, result, test.result)
+		}
+	}
+
+	for _, spec := range []string{"", "q", "0", "200d", "-4d"} {
+		_, err := opcode.ParseFormat(spec)
+		if err == nil {
+			t.Errorf("expected an error parsing %q", spec)
+		}
+	}
+}
+
+// Test the sprintf trap.
+func TestSprintf(t *testing.T) {
+
+	tests := []struct {
+		src    string
+		result string
+		kind   FaultKind
+	}{
+		{`store #0, "%d%%"`, "0%", 0},
+		{`store #0, "[%s] %3d %c"` + "\nstore #1, \"x\"\nstore #2, 7\nstore #3, 0x41", "[x]   7 A", 0},
+		{`store #0, "%s"`, "", TypeFault},
+		{`store #0, "%q"`, "", TrapFault},
+		{`store #0, "%05"`, "", TrapFault},
+	}
+
+	for _, test := range tests {
+		c := NewCPU()
+		c.LoadBytes(compile(t, test.src+"\nint 0x06\n"))
+		_, err := c.Run()
+
+		if test.kind != 0 {
+			f, ok := err.(*Fault)
+			if !ok || f.Kind != test.kind {
+				t.Errorf("expected fault %d running %q, got %v", test.kind, test.src, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error running %q: %s", test.src, err.Error())
+		}
+		str, _ := c.getString(0)
+		if str != test.result {
+			t.Errorf("running %q gave %q, expected %q", test.src, str, test.result)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// This file contains the reading of input, by the `read_*` instructions
+// and the trap which reads a string.
+//
+// Each CPU reads from its own input stream, which is STDIN unless another
+// is given via SetInput.  Every read sets the flags to report what
+// happened:
+//
+//   carry clear    - a value was read.
+//   carry set      - nothing was read, due to an error or the end of the
+//                    input.  read_int also sets it when the line it read
+//                    wasn't a number.
+//   zero set       - nothing was read as the input has ended.
+//
+// The reads are exported so that programs generated by `go.vm togo`
+// behave identically.
+
+package cpu
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdin is shared by every CPU which reads from STDIN, so that input
+// buffered by one isn't lost to the others.
+var stdin = bufio.NewReader(os.Stdin)
+
+// SetInput sets the stream the program reads its input from.
+func (c *CPU) SetInput(r io.Reader) {
+	c.input = bufio.NewReader(r)
+}
+
+// Input returns the stream the program reads its input from, so that
+// devices may share it.
+func (c *CPU) Input() io.Reader {
+	return c.reader()
+}
+
+// reader returns the stream the program reads its input from.
+func (c *CPU) reader() *bufio.Reader {
+	if c.input == nil {
+		return stdin
+	}
+	return c.input
+}
+
+// readFlags returns the flags which report the result of a read.
+func readFlags(err error) Flags {
+	if err == io.EOF {
+		return Flags{z: true, c: true}
+	}
+	return Flags{c: err != nil}
+}
+
+// InputLine reads a line of input, without its trailing newline, and
+// returns it along with the flags it sets.
+//
+// A final line which has no newline is returned as-is, the end of the
+// input is reported by the read which follows it.
+func (c *CPU) InputLine() (string, Flags) {
+	line, err := c.reader().ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, readFlags(err)
+}
+
+// InputByte reads a single byte of input, and returns it along with the
+// flags it sets.  If nothing was read -1 is returned.
+func (c *CPU) InputByte() (int, Flags) {
+	b, err := c.reader().ReadByte()
+	if err != nil {
+		return -1, readFlags(err)
+	}
+	return int(b), Flags{}
+}
+
+// InputChar reads a single (UTF-8) character of input, and returns it as
+// a string along with the flags it sets.  If nothing was read the string
+// is empty.
+func (c *CPU) InputChar() (string, Flags) {
+	r, _, err := c.reader().ReadRune()
+	if err != nil {
+		return "", readFlags(err)
+	}
+	return string(r), Flags{}
+}
+
+// InputInt reads a line of input holding an integer, written as the
+// compiler accepts them, and returns it along with the flags it sets.  If
+// nothing was read, or the line wasn't a number, zero is returned.
+func (c *CPU) InputInt() (int, Flags) {
+	line, f := c.InputLine()
+	if f.Carry() {
+		return 0, f
+	}
+
+	i, err := ParseInt(strings.TrimSpace(line), 0)
+	if err != nil {
+		return 0, Flags{c: true}
+	}
+	return i, f
+}
@@ -0,0 +1,66 @@
+package cpu
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test the instructions which read input, and the flags they set.
+func TestInput(t *testing.T) {
+
+	src := `
+        read_line #0
+        read_byte #1
+        read_char #2
+        read_line #3
+        read_int #4
+        read_int #5
+        read_line #6
+        read_int #7
+`
+	c := NewCPU()
+	c.SetInput(strings.NewReader("Hello\r\nAé\n-12\nfish\nlast"))
+	c.LoadBytes(compile(t, "bits 32\n"+src))
+	_, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	strs := map[int]string{0: "Hello", 2: "é", 3: "", 6: "last"}
+	for reg, expected := range strs {
+		val, err := c.getString(reg)
+		if err != nil || val != expected {
+			t.Errorf("register #%d holds %q (%v), expected %q", reg, val, err, expected)
+		}
+	}
+
+	ints := map[int]int{1: 'A', 4: -12, 5: 0, 7: 0}
+	for reg, expected := range ints {
+		val, err := c.getInt(reg)
+		if err != nil || val != expected {
+			t.Errorf("register #%d holds %d (%v), expected %d", reg, val, err, expected)
+		}
+	}
+
+	// The final read found the end of the input.
+	if !c.flags.Carry() || !c.flags.Zero() {
+		t.Errorf("expected the carry and zero flags to be set, got %+v", c.flags)
+	}
+}
+
+// Test the flags set by each read.
+func TestInputFlags(t *testing.T) {
+
+	c := NewCPU()
+	c.SetInput(strings.NewReader("x\n"))
+
+	if _, f := c.InputInt(); f != (Flags{c: true}) {
+		t.Errorf("reading an invalid number gave flags %+v", f)
+	}
+	if b, f := c.InputByte(); b != -1 || f != (Flags{z: true, c: true}) {
+		t.Errorf("reading at the end of the input gave %d, %+v", b, f)
+	}
+	if s, f := c.InputChar(); s != "" || f != (Flags{z: true, c: true}) {
+		t.Errorf("reading at the end of the input gave %q, %+v", s, f)
+	}
+}
@@ -0,0 +1,221 @@
+// This file contains the interrupts, which allow a program to respond to
+// events as they happen rather than polling for them.
+//
+// An interrupt may be raised by the host, via Interrupt, or by a device
+// such as the timer.  It remains pending until interrupts are enabled via
+// `ei`, at which point the CPU calls its handler before executing the
+// next instruction.  If several are pending the lowest-numbered is taken
+// first.
+//
+// The address of the handler for each interrupt is read from the vector
+// table, which occupies the last 32 bytes of RAM - two bytes for each
+// interrupt, stored as words are.  An interrupt whose vector is zero has
+// no handler, and is discarded.
+//
+// Interrupts are disabled while a handler runs, unless it enables them
+// again.  The handler returns via `iret`, which restores the flags and
+// re-enables interrupts, so it needn't preserve the flags itself but must
+// preserve any registers it uses.
+
+package cpu
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Interrupts is the number of interrupts.
+const Interrupts = 16
+
+// Interrupt raises the given interrupt.  It may be called while the
+// program is running, from any goroutine.
+func (c *CPU) Interrupt(n int) error {
+	if n < 0 || n >= Interrupts {
+		return fmt.Errorf("interrupt %d out of range", n)
+	}
+	for {
+		old := atomic.LoadUint32(&c.pending)
+		if atomic.CompareAndSwapUint32(&c.pending, old, old|1<<uint(n)) {
+			return nil
+		}
+	}
+}
+
+// Vectors returns the address of the vector table.
+func (c *CPU) Vectors() int {
+	return len(c.mem) - 2*Interrupts
+}
+
+// dispatch calls the handler for the lowest-numbered pending interrupt,
+// if it has one.
+func (c *CPU) dispatch() error {
+	for n := 0; n < Interrupts; n++ {
+		bit := uint32(1) << uint(n)
+		old := atomic.LoadUint32(&c.pending)
+		if old&bit == 0 {
+			continue
+		}
+		if !atomic.CompareAndSwapUint32(&c.pending, old, old&^bit) {
+			// Another interrupt was raised, so look again.
+			n--
+			continue
+		}
+
+		vector, err := c.loadMem(c.Vectors()+2*n, 2)
+		if err != nil {
+			return err
+		}
+		target := int(vector[0]) | int(vector[1])<<8
+		if target == 0 {
+			continue
+		}
+		if err = c.checkMem(target, 1); err != nil {
+			return err
+		}
+
+		// Record the state to restore, upon the call stack.
+		err = c.call(target, c.ip, c.ip)
+		if err != nil {
+			return err
+		}
+		f := &c.calls[len(c.calls)-1]
+		f.irq, f.flags = true, c.flags
+
+		c.ie = false
+		c.ip = target
+		return nil
+	}
+	return nil
+}
+
+// opIntEnable enables interrupts.
+func opIntEnable(c *CPU, in *instruction) error {
+	c.ie = true
+	return nil
+}
+
+// opIntDisable disables interrupts, those raised remain pending.
+func opIntDisable(c *CPU, in *instruction) error {
+	c.ie = false
+	return nil
+}
+
+// opIntRet returns from an interrupt handler.
+func opIntRet(c *CPU, in *instruction) error {
+	if len(c.calls) == 0 || !c.calls[len(c.calls)-1].irq {
+		return fault(StackFault, "Return without an interrupt")
+	}
+
+	f := c.calls[len(c.calls)-1]
+	c.calls = c.calls[:len(c.calls)-1]
+
+	c.fp = f.fp
+	c.flags = f.flags
+	c.ie = true
+	c.ip = f.ret
+	return nil
+}
+
+//
+// Devices now follow
+//
+
+// Ticker is implemented by devices which must be run after every
+// instruction, such as the timer.  Tick is called with the CPU the device
+// is attached to.
+type Ticker interface {
+	Tick(c *CPU)
+}
+
+// TimerSize is the number of addresses a Timer occupies.
+const TimerSize = 4
+
+// The bits of the control register of a Timer.
+const (
+	// TimerEnable starts the timer.
+	TimerEnable = 0x01
+
+	// TimerWall counts the period in milliseconds, rather than in
+	// executed instructions.
+	TimerWall = 0x02
+)
+
+// Timer is a device which raises an interrupt periodically.
+//
+// Its first address is the control register, see TimerEnable, and the
+// following two hold the period as a word.  The last holds the interrupt
+// which is raised.  Writing the control register restarts the period.
+//
+// Counting executed instructions means a program behaves identically
+// every time it is run, while counting milliseconds allows it to do work
+// at regular intervals.
+type Timer struct {
+	control byte
+	period  int
+	irq     byte
+
+	// The instructions executed, or the time, since the last interrupt.
+	count int
+	start time.Time
+}
+
+// NewTimer returns a stopped timer.
+func NewTimer() *Timer {
+	return &Timer{}
+}
+
+// Read returns the contents of a register of the timer.
+func (t *Timer) Read(offset int) (byte, error) {
+	switch offset {
+	case 0:
+		return t.control, nil
+	case 1:
+		return byte(t.period), nil
+	case 2:
+		return byte(t.period >> 8), nil
+	}
+	return t.irq, nil
+}
+
+// Write sets the contents of a register of the timer.
+func (t *Timer) Write(offset int, val byte) error {
+	switch offset {
+	case 0:
+		t.control = val
+		t.count = 0
+		t.start = time.Now()
+	case 1:
+		t.period = t.period&0xFF00 | int(val)
+	case 2:
+		t.period = t.period&0x00FF | int(val)<<8
+	default:
+		if int(val) >= Interrupts {
+			return fmt.Errorf("interrupt %d out of range", val)
+		}
+		t.irq = val
+	}
+	return nil
+}
+
+// Tick raises the interrupt of the timer, once each period has passed.
+func (t *Timer) Tick(c *CPU) {
+	if t.control&TimerEnable == 0 || t.period == 0 {
+		return
+	}
+
+	if t.control&TimerWall == 0 {
+		t.count++
+		if t.count < t.period {
+			return
+		}
+		t.count = 0
+	} else {
+		period := time.Duration(t.period) * time.Millisecond
+		if time.Since(t.start) < period {
+			return
+		}
+		t.start = t.start.Add(period)
+	}
+	c.Interrupt(int(t.irq))
+}
@@ -0,0 +1,114 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// Test that an interrupt raised by the host calls its handler, which
+// returns with the flags restored.
+func TestInterrupt(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+        store #1, handler
+        store #2, 0xFFE2
+        pokew #1, #2
+
+        # Set the carry-flag, which the handler clears.
+        store #3, 1
+        store #4, 2
+        cmp #3, #4
+        ei
+        jnc bad
+        cmp #5, 1
+        jmpnz bad
+        exit 0
+:bad
+        exit 1
+:handler
+        store #5, 1
+        cmp #5, #5
+        iret
+`))
+
+	if c.Interrupt(Interrupts) == nil {
+		t.Errorf("expected an invalid interrupt to be rejected")
+	}
+	c.Interrupt(1)
+
+	status, err := c.Run()
+	if err != nil || status != 0 {
+		t.Errorf("handler wasn't called, or didn't restore the flags: %d %v", status, err)
+	}
+}
+
+// Test that the timer raises its interrupt after each period, counted in
+// instructions or milliseconds.
+func TestTimer(t *testing.T) {
+	src := `
+        store #1, tick
+        store #2, 0xFFE4
+        pokew #1, #2
+
+        # Raise interrupt 2 every ten instructions, or milliseconds.
+        store #1, 0xFF01
+        store #2, 10
+        pokew #2, #1
+        store #1, 0xFF03
+        store #2, 2
+        poke #2, #1
+        store #1, 0xFF00
+        poke #0, #1
+        ei
+:loop
+        cmp #7, 3
+        jmpnz loop
+        di
+        exit
+:tick
+        inc #7
+        iret
+`
+
+	for _, control := range []int{TimerEnable, TimerEnable | TimerWall} {
+		c := NewCPU()
+		c.Attach(0xFF00, TimerSize, NewTimer())
+		c.LoadBytes(compile(t, src))
+		c.setInt(0, control)
+
+		if _, err := c.Run(); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if v, _ := c.getInt(7); v != 3 {
+			t.Errorf("timer fired %d times", v)
+		}
+	}
+}
+
+// Test that interrupts without handlers are discarded, and that returning
+// from them incorrectly faults.
+func TestInterruptFaults(t *testing.T) {
+
+	tests := []struct {
+		src string
+		ok  bool
+	}{
+		{"ei\nnop\nexit\n", true},
+		{"iret\n", false},
+		{"store #1, handler\nstore #2, 0xFFE2\npokew #1, #2\nei\nnop\nexit\n:handler\nret\n", false},
+		{"call fn\nexit\n:fn\niret\n", false},
+	}
+
+	for _, test := range tests {
+		c := NewCPU()
+		c.LoadBytes(compile(t, test.src))
+		c.Interrupt(1)
+
+		_, err := c.Run()
+		if test.ok && err != nil {
+			t.Errorf("unexpected error running %q: %s", test.src, err.Error())
+		}
+		if f, ok := err.(*Fault); !test.ok && (!ok || f.Kind != StackFault) {
+			t.Errorf("expected a stack fault running %q, got %v", test.src, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// This file contains the limits which bound the resources a program may
+// consume, so that a runaway program cannot exhaust the memory of the host.
+
+package cpu
+
+// Limits bounds the resources a program may use.  A limit of zero means
+// there is no limit.
+type Limits struct {
+	// MaxString is the maximum length of a single string, or byte-array.
+	MaxString int
+
+	// MaxStrings is the maximum number of bytes of strings, and
+	// byte-arrays, which may be held in all registers and on the stack
+	// combined.
+	MaxStrings int
+
+	// MaxStack is the maximum number of entries on the stack.
+	MaxStack int
+}
+
+// DefaultLimits are the limits a new CPU is created with.
+var DefaultLimits = Limits{
+	MaxString:  1024 * 1024,
+	MaxStrings: 16 * 1024 * 1024,
+	MaxStack:   64 * 1024,
+}
+
+// SetLimits sets the limits on the resources the program may use.
+func (c *CPU) SetLimits(l Limits) {
+	c.limits = l
+}
+
+// size returns the length of the string, or byte-array, held in the given
+// object, and false if it holds neither.
+//
+// A map counts as the total length of the strings it holds, plus one byte
+// for each entry, so that maps of integers are limited too - see
+// entrySize.
+func size(o Object) (int, bool) {
+	switch v := o.(type) {
+	case *StringObject:
+		return len(v.Value), true
+	case *BytesObject:
+		return len(v.Value), true
+	case *MapObject:
+		return v.used, true
+	}
+	return 0, false
+}
+
+// checkString ensures that a string of the given length may be stored in
+// the given register.
+//
+// Byte-arrays and maps are subject to the same limits as strings, so this
+// is used for them too.
+func (c *CPU) checkString(reg int, length int) error {
+	_, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	if c.limits.MaxString > 0 && length > c.limits.MaxString {
+		return fault(StringLengthFault, "String of %d bytes exceeds the limit of %d", length, c.limits.MaxString)
+	}
+
+	return c.checkStrings(reg, length)
+}
+
+// checkStrings ensures that adding the given number of bytes of strings
+// keeps the total held in the registers, other than the given one, and on
+// the stack within our limits.  Pass a register of -1 to count them all.
+func (c *CPU) checkStrings(reg int, length int) error {
+	if c.limits.MaxStrings <= 0 {
+		return nil
+	}
+
+	total := length + c.stack.Bytes()
+	for i, r := range c.regs {
+		if n, ok := size(r.o); ok && i != reg {
+			total += n
+		}
+	}
+	if total > c.limits.MaxStrings {
+		return fault(StringMemoryFault, "Strings of %d bytes exceed the limit of %d", total, c.limits.MaxStrings)
+	}
+	return nil
+}
+
+// setString stores the given string in a register, if the limits allow.
+func (c *CPU) setString(reg int, str string) error {
+	r, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+	err = c.checkString(reg, len(str))
+	if err != nil {
+		return err
+	}
+	r.SetString(str)
+	return nil
+}
+
+// push adds values to the stack, if the limits allow.  Either all of the
+// values are pushed, or none of them are.
+//
+// The values are pushed in order, so the last is at the top of the stack.
+func (c *CPU) push(values ...Object) error {
+	if c.limits.MaxStack > 0 && c.stack.Size()+len(values) > c.limits.MaxStack {
+		return fault(StackOverflowFault, "Stack Overflow!")
+	}
+	total := 0
+	for _, value := range values {
+		n, _ := size(value)
+		total += n
+	}
+	if total > 0 {
+		err := c.checkStrings(-1, total)
+		if err != nil {
+			return err
+		}
+	}
+	for _, value := range values {
+		c.stack.Push(value)
+	}
+	return nil
+}
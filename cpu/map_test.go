@@ -0,0 +1,99 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// Test the map instructions.
+func TestMap(t *testing.T) {
+
+	src := `
+        map #0
+        store #1, "b"
+        store #2, 2
+        mapset #0, #1, #2
+        store #1, 10
+        mapset #0, #1, #1
+        store #1, "a"
+        mapset #0, #1, #1
+        store #1, 1
+        store #2, "one"
+        mapset #0, #1, #2
+        store #3, #0
+        mapdel #3, #1
+        keys #4, #0
+        store #1, "a"
+        haskey #0, #1
+`
+	c := NewCPU()
+	c.LoadBytes(compile(t, src))
+	_, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	if !c.flags.Zero() {
+		t.Errorf("expected the Z-flag to be set by haskey")
+	}
+
+	// The keys are sorted, integers first.
+	expected := []interface{}{1, 10, "a", "b"}
+	count, _ := c.getInt(4)
+	if count != len(expected) {
+		t.Fatalf("expected %d keys, got %d", len(expected), count)
+	}
+	for _, key := range expected {
+		val, _ := c.stack.Pop()
+		switch v := val.(type) {
+		case *IntegerObject:
+			if v.Value != key {
+				t.Errorf("expected key %v, got %d", key, v.Value)
+			}
+		case *StringObject:
+			if v.Value != key {
+				t.Errorf("expected key %v, got %q", key, v.Value)
+			}
+		}
+	}
+
+	// The copy is independent of the original.
+	m, _ := c.getMap(0)
+	if _, ok := m.Value[1]; !ok {
+		t.Errorf("deleting from a copy changed the original")
+	}
+	if v, ok := m.Value[10].(*IntegerObject); !ok || v.Value != 10 {
+		t.Errorf("the value of key 10 is %v", m.Value[10])
+	}
+
+	// Integers and strings which look alike are different keys.
+	if _, ok := m.Value["1"]; ok {
+		t.Errorf("the string \"1\" was found as a key")
+	}
+}
+
+// Test that maps are subject to the limits on strings.
+func TestMapLimits(t *testing.T) {
+
+	src := `
+        map #0
+        store #1, 0
+        store #2, "xxxxxxxx"
+:loop
+        mapset #0, #1, #2
+        inc #1
+        jmp loop
+`
+	c := NewCPU()
+	c.SetLimits(Limits{MaxStrings: 1000})
+	c.LoadBytes(compile(t, src))
+	_, err := c.Run()
+
+	f, ok := err.(*Fault)
+	if !ok || f.Kind != StringMemoryFault {
+		t.Fatalf("expected a string-memory fault, got %v", err)
+	}
+	m, _ := c.getMap(0)
+	if len(m.Value) != 110 {
+		t.Errorf("expected 110 entries before the fault, got %d", len(m.Value))
+	}
+}
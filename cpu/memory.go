@@ -0,0 +1,165 @@
+// This file contains the RAM of the CPU, and the access to it made by the
+// memory instructions such as `peek`, `store_mem` and `memset`.
+//
+// RAM is 64K by default, which SetMemory changes.  Addresses are never
+// wrapped around to fit within it, instead:
+//
+//   * Every access made by an instruction is checked against the size of
+//     RAM, and faults with a MemoryFault naming the first address which
+//     lies outside.  Nothing is written if any byte would be outside.
+//
+//   * Jumps, and calls, to an address outside RAM fault in the same way.
+//
+//   * The exception is the IP, which runs from the end of RAM back to the
+//     start, so an instruction may straddle the end - see decode.go.
+//
+// Words are stored in little-endian order, as the compiler stores them,
+// and strings are followed by a NUL byte.  Addresses may be backed by a
+// device rather than RAM, see device.go.
+
+package cpu
+
+import (
+	"fmt"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// DefaultMemory is the size of the RAM a new CPU is created with, which
+// holds every address an instruction may jump to.
+const DefaultMemory = 0x10000
+
+// MaxMemory is the largest RAM a CPU may be given.
+const MaxMemory = 0x1000000
+
+// SetMemory sets the size of RAM, in bytes.  RAM is cleared, any devices
+// are detached, and the CPU is reset, so this must be called before the
+// program is loaded.
+func (c *CPU) SetMemory(size int) error {
+	if size < 1 || size > MaxMemory {
+		return fmt.Errorf("the size of RAM must be between 1 and %d bytes", MaxMemory)
+	}
+	c.code = nil
+	c.perms = nil
+	c.devices = nil
+	c.tickers = nil
+	c.mem = make([]byte, size)
+	c.index = make([]int32, size)
+	c.covered = make([]bool, size)
+	c.Reset()
+	return nil
+}
+
+// Memory returns the size of RAM, in bytes.
+func (c *CPU) Memory() int {
+	return len(c.mem)
+}
+
+// AddressFault returns the fault raised when the given address is outside
+// RAM.
+//
+// It is exported so that programs generated by `go.vm togo` behave
+// identically.
+func AddressFault(addr int) error {
+	if addr < 0 {
+		return fault(MemoryFault, "Address %d is outside RAM", addr)
+	}
+	return fault(MemoryFault, "Address %04X is outside RAM", addr)
+}
+
+// checkMem tests that the n bytes beginning at addr lie within RAM.
+func (c *CPU) checkMem(addr int, n int) error {
+	if addr < 0 {
+		return AddressFault(addr)
+	}
+	if n > 0 && addr+n > len(c.mem) {
+		if addr < len(c.mem) {
+			addr = len(c.mem)
+		}
+		return AddressFault(addr)
+	}
+	return nil
+}
+
+// checkValue tests that the given integer may be stored in RAM, in the
+// given number of bytes.
+func checkValue(val int, n int) error {
+	max := 1<<(8*uint(n)) - 1
+	if val < 0 || val > max {
+		return fault(ValueFault, "Value %d is outside the range 0-%d", val, max)
+	}
+	return nil
+}
+
+// jump sets the IP to the given address, faulting if it is outside RAM.
+func (c *CPU) jump(addr int) error {
+	if err := c.checkMem(addr, 1); err != nil {
+		return err
+	}
+	c.ip = addr
+	return nil
+}
+
+// loadMem returns the n bytes of RAM beginning at addr.
+func (c *CPU) loadMem(addr int, n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fault(IndexFault, "Invalid length %d", n)
+	}
+	if err := c.checkMem(addr, n); err != nil {
+		return nil, err
+	}
+	if err := c.checkPerm(addr, n, opcode.PermRead); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, n)
+	if c.devices == nil {
+		copy(out, c.mem[addr:addr+n])
+		return out, nil
+	}
+	for i := range out {
+		b, err := c.readByte(addr + i)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+// storeMem writes the given bytes to RAM, beginning at addr.
+func (c *CPU) storeMem(addr int, data []byte) error {
+	if err := c.checkMem(addr, len(data)); err != nil {
+		return err
+	}
+	if err := c.checkPerm(addr, len(data), opcode.PermWrite); err != nil {
+		return err
+	}
+	for i, b := range data {
+		if err := c.writeByte(addr+i, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadString returns the NUL-terminated string stored at addr.
+func (c *CPU) loadString(addr int) (string, error) {
+	var out []byte
+	for i := addr; ; i++ {
+		if err := c.checkMem(i, 1); err != nil {
+			return "", err
+		}
+		if err := c.checkPerm(i, 1, opcode.PermRead); err != nil {
+			return "", err
+		}
+		b, err := c.readByte(i)
+		if err != nil {
+			return "", err
+		}
+		if b == 0 {
+			return string(out), nil
+		}
+		out = append(out, b)
+	}
+}
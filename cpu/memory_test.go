@@ -0,0 +1,212 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// Test the memory instructions, via the registers they leave behind.
+func TestMemory(t *testing.T) {
+
+	src := `
+        store #0, 0x8000
+        store #1, 0x1234
+        pokew #1, #0
+        peekw #2, #0
+        peek #3, #0
+        store #4, "hello"
+        store #5, 0x8010
+        store_mem #4, #5
+        load_mem #6, #5
+        store #7, 3
+        load_mem #8, #5, #7
+        store #9, 0x8020
+        store #10, 0x41
+        memset #9, #10, #7
+        load_mem #11, #9
+        memcmp #5, #9, #7
+        jmpz bad
+        jc bad
+        memcmp #9, #5, #7
+        jnc bad
+        memcmp #9, #9, #7
+        jmpnz bad
+        exit 0
+:bad
+        exit 1
+`
+	c := NewCPU()
+	c.LoadBytes(compile(t, src))
+	status, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if status != 0 {
+		t.Errorf("a comparison failed")
+	}
+
+	ints := map[int]int{2: 0x1234, 3: 0x34}
+	for reg, expected := range ints {
+		val, err := c.getInt(reg)
+		if err != nil || val != expected {
+			t.Errorf("register #%d holds %d (%v), expected %d", reg, val, err, expected)
+		}
+	}
+	strs := map[int]string{6: "hello", 8: "hel", 11: "AAA"}
+	for reg, expected := range strs {
+		val, err := c.getString(reg)
+		if err != nil || val != expected {
+			t.Errorf("register #%d holds %q (%v), expected %q", reg, val, err, expected)
+		}
+	}
+}
+
+// Test that accesses which reach beyond RAM fault, without writing any
+// of the bytes which are inside it.
+func TestMemoryBounds(t *testing.T) {
+	c := NewCPU()
+
+	end := len(c.mem)
+	tests := []struct {
+		addr int
+		n    int
+		ok   bool
+	}{
+		{0, 1, true},
+		{end - 2, 2, true},
+		{end, 0, true},
+		{end - 1, 2, false},
+		{end, 1, false},
+		{-1, 1, false},
+	}
+	for _, test := range tests {
+		err := c.checkMem(test.addr, test.n)
+		if (err == nil) != test.ok {
+			t.Errorf("checking %d bytes at %d gave %v", test.n, test.addr, err)
+		}
+	}
+
+	err := c.storeMem(end-1, []byte{1, 2})
+	if f, ok := err.(*Fault); !ok || f.Kind != MemoryFault {
+		t.Errorf("expected a memory fault, got %v", err)
+	}
+	if c.mem[end-1] != 0 {
+		t.Errorf("a partial write was made")
+	}
+
+	// A string which isn't terminated before the end of RAM.
+	c.mem[end-1] = 'x'
+	if _, err = c.loadString(end - 1); err == nil {
+		t.Errorf("expected an unterminated string to fault")
+	}
+}
+
+// Test that poke and pokew store bytes and words, faulting for integers
+// which don't fit, and that peek, poke and memcpy fault outside RAM.
+func TestPeekPoke(t *testing.T) {
+
+	src := `
+        bits 32
+        store #0, 0x8000
+        store #1, 0xFF
+        poke #1, #0
+        peek #2, #0
+        store #1, 0xFFFF
+        pokew #1, #0
+        peekw #3, #0
+        exit
+`
+	c := NewCPU()
+	c.LoadBytes(compile(t, src))
+	if _, err := c.Run(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	ints := map[int]int{2: 0xFF, 3: 0xFFFF}
+	for reg, expected := range ints {
+		val, err := c.getInt(reg)
+		if err != nil || val != expected {
+			t.Errorf("register #%d holds %d (%v), expected %d", reg, val, err, expected)
+		}
+	}
+
+	tests := []struct {
+		src  string
+		kind FaultKind
+	}{
+		{"store #1, 0x10000\npeek #2, #1\n", MemoryFault},
+		{"store #1, -1\npeek #2, #1\n", MemoryFault},
+		{"store #1, 0x10000\nstore #2, 0\npoke #2, #1\n", MemoryFault},
+		{"store #1, -1\nstore #2, 0\npoke #2, #1\n", MemoryFault},
+		{"store #1, 0xFFFF\npokew #1, #1\n", MemoryFault},
+		{"store #1, 0xFFFF\nstore #2, 0\nstore #3, 2\nmemcpy #1, #2, #3\n", MemoryFault},
+		{"store #1, 0\nstore #2, 0xFFFF\nstore #3, 2\nmemcpy #1, #2, #3\n", MemoryFault},
+		{"store #1, 0\nstore #2, 0\nstore #3, -1\nmemcpy #1, #2, #3\n", IndexFault},
+		{"store #1, 0xFFFF\nstore #2, 0x100\npoke #2, #1\n", ValueFault},
+		{"store #1, 0xFFFF\nstore #2, -1\npoke #2, #1\n", ValueFault},
+		{"store #1, 0xFFFE\nstore #2, 0x10000\npokew #2, #1\n", ValueFault},
+		{"store #1, 0xFFFE\nstore #2, -1\npokew #2, #1\n", ValueFault},
+		{"store #1, 0xFFFF\nstore #2, 0x100\nstore #3, 1\nmemset #1, #2, #3\n", ValueFault},
+	}
+	for _, test := range tests {
+		c = NewCPU()
+		c.LoadBytes(compile(t, "bits 32\n"+test.src))
+		c.mem[len(c.mem)-1] = 0x42
+
+		_, err := c.Run()
+		if f, ok := err.(*Fault); !ok || f.Kind != test.kind {
+			t.Errorf("expected fault %d running %q, got %v", test.kind, test.src, err)
+		}
+		if c.mem[len(c.mem)-1] != 0x42 {
+			t.Errorf("running %q made a partial write", test.src)
+		}
+	}
+}
+
+// Test that the size of RAM may be changed, and that the IP wraps around
+// it while jumps outside it fault.
+func TestSetMemory(t *testing.T) {
+	c := NewCPU()
+	if c.Memory() != DefaultMemory {
+		t.Errorf("RAM holds %d bytes by default", c.Memory())
+	}
+	if c.SetMemory(0) == nil || c.SetMemory(MaxMemory+1) == nil {
+		t.Errorf("expected an invalid size to be rejected")
+	}
+
+	// The last address of the default RAM holds an `exit`.
+	c.LoadBytes(compile(t, "goto 0xFFFF\n"))
+	if _, err := c.Run(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+
+	c.SetMemory(0x100)
+	err := c.LoadBytes(make([]byte, 0x101))
+	if err == nil {
+		t.Errorf("expected a program larger than RAM to be rejected")
+	}
+
+	tests := []struct {
+		src string
+		ok  bool
+	}{
+		{"goto 0x100\n", false},
+		{"call 0x100\n", false},
+		{"store #1, 0x100\npeek #2, #1\n", false},
+		{"store #1, 0xFF\npeek #2, #1\nexit\n", true},
+	}
+	for _, test := range tests {
+		c.LoadBytes(compile(t, test.src))
+
+		_, err = c.Run()
+		if f, ok := err.(*Fault); (err == nil) != test.ok || (err != nil && (!ok || f.Kind != MemoryFault)) {
+			t.Errorf("running %q gave %v", test.src, err)
+		}
+	}
+
+	// A `nop` at the end of RAM runs into the `exit` at the start.
+	c.LoadBytes(compile(t, "exit\n"))
+	c.mem[0xFF] = 0x50
+	c.ip = 0xFF
+	if _, err = c.Run(); err != nil {
+		t.Errorf("unexpected error: %s", err.Error())
+	}
+}
@@ -0,0 +1,1628 @@
+// This file contains the implementation of each of our opcodes.
+//
+// Every handler receives the decoded instruction it is executing, by the
+// time it is invoked the IP has already been moved past that instruction.
+// If the instruction cannot be executed the handler returns a fault.
+
+package cpu
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// handlers holds the function which implements each opcode.
+var handlers [256]handler
+
+// opUnknown handles any opcode we don't recognize.
+func opUnknown(c *CPU, in *instruction) error {
+	return fault(OpcodeFault, "Unrecognized/Unimplemented opcode %02X", in.Op)
+}
+
+// opExit terminates execution.
+func opExit(c *CPU, in *instruction) error {
+	c.halted = true
+	c.status = 0
+	return nil
+}
+
+// opExitImmediate terminates execution with the given status.
+func opExitImmediate(c *CPU, in *instruction) error {
+	return c.exit(in.Args[0])
+}
+
+// opExitReg terminates execution with the status held in a register.
+func opExitReg(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	val, err := c.getInt(reg)
+	if err != nil {
+		return err
+	}
+	return c.exit(val)
+}
+
+// exit terminates execution with the given status, which must not be
+// mistaken for the status of a fault.
+func (c *CPU) exit(status int) error {
+	if status < 0 || status > opcode.MaxStatus {
+		return fault(StatusFault, "Exit status %d is outside the range 0-%d", status, opcode.MaxStatus)
+	}
+	c.halted = true
+	c.status = status
+	return nil
+}
+
+// opNop does nothing.
+func opNop(c *CPU, in *instruction) error {
+	return nil
+}
+
+//
+// Integer operations
+//
+
+// opIntStore stores an integer in a register.
+func opIntStore(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	return c.setInt(reg, in.Args[1])
+}
+
+// opIntPrint prints the integer contents of a register, in hex.
+func opIntPrint(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	val, err := c.getInt(reg)
+	if err != nil {
+		return err
+	}
+
+	if val < 256 {
+		fmt.Printf("%02X", val)
+	} else {
+		fmt.Printf("%04X", val)
+	}
+	return nil
+}
+
+// opIntPrintFmt prints the integer contents of a register, in the given
+// format.
+func opIntPrintFmt(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	val, err := c.getInt(reg)
+	if err != nil {
+		return err
+	}
+
+	str, err := c.width.Format(val, in.Args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s", str)
+	return nil
+}
+
+// opIntToString converts the integer contents of a register to a string.
+func opIntToString(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	// get value
+	i, err := c.getInt(reg)
+	if err != nil {
+		return err
+	}
+
+	// change from int to string
+	return c.setString(reg, fmt.Sprintf("%d", i))
+}
+
+// opIntRandom stores a random number in a register.
+func opIntRandom(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	// New random source
+	s1 := rand.NewSource(time.Now().UnixNano())
+	r1 := rand.New(s1)
+
+	// New random number
+	return c.setInt(reg, r1.Intn(0xffff))
+}
+
+//
+// Control-flow
+//
+
+// opJump is an unconditional jump.
+func opJump(c *CPU, in *instruction) error {
+	return c.jump(in.Args[0])
+}
+
+// jumpIf returns a handler which jumps if the given condition holds, see
+// flags.go.
+func jumpIf(cond func(f Flags, w Width) bool) handler {
+	return func(c *CPU, in *instruction) error {
+		if cond(c.flags, c.width) {
+			return c.jump(in.Args[0])
+		}
+		return nil
+	}
+}
+
+//
+// Math operations
+//
+
+// mathFunc is the signature of the functions which implement the math
+// operations, see flags.go.
+type mathFunc func(w Width, a, b int) (int, Flags)
+
+// mathOperands returns the values of the two source operands of a math
+// operation.  The second is a register, unless the immediate form of the
+// operation is being executed.
+func (c *CPU) mathOperands(in *instruction, immediate bool) (int, int, error) {
+	aVal, err := c.getInt(in.Args[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	if immediate {
+		return aVal, c.width.Wrap(in.Args[2]), nil
+	}
+	bVal, err := c.getInt(in.Args[2])
+	if err != nil {
+		return 0, 0, err
+	}
+	return aVal, bVal, nil
+}
+
+// mathOp returns a handler which stores the result of applying fn to the
+// two source operands in the destination register, and updates the flags.
+func mathOp(fn mathFunc, immediate bool) handler {
+	return func(c *CPU, in *instruction) error {
+		res := in.Args[0]
+
+		aVal, bVal, err := c.mathOperands(in, immediate)
+		if err != nil {
+			return err
+		}
+		_, err = c.register(res)
+		if err != nil {
+			return err
+		}
+
+		// store result
+		val, flags := fn(c.width, aVal, bVal)
+		c.flags = flags
+		return c.setInt(res, val)
+	}
+}
+
+// divideOp returns a handler like mathOp, which faults if the divisor is
+// zero.
+func divideOp(fn mathFunc, immediate bool) handler {
+	op := mathOp(fn, immediate)
+	return func(c *CPU, in *instruction) error {
+		_, bVal, err := c.mathOperands(in, immediate)
+		if err != nil {
+			return err
+		}
+
+		if bVal == 0 {
+			return fault(DivideFault, "Attempting to divide by zero - denying")
+		}
+		return op(c, in)
+	}
+}
+
+// unaryOp returns a handler which replaces the contents of a register
+// with the result of applying fn to it, and updates the flags.
+func unaryOp(fn func(w Width, a int) (int, Flags)) handler {
+	return func(c *CPU, in *instruction) error {
+		reg := in.Args[0]
+
+		val, err := c.getInt(reg)
+		if err != nil {
+			return err
+		}
+
+		val, c.flags = fn(c.width, val)
+		return c.setInt(reg, val)
+	}
+}
+
+//
+// String operations
+//
+
+// opStringStore stores a string in a register.
+func opStringStore(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	// store the string
+	return c.setString(reg, in.Str)
+}
+
+// opStringPrint prints the string contents of a register.
+func opStringPrint(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	str, err := c.getString(reg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s", str)
+	return nil
+}
+
+// opStringConcat joins the contents of two string registers.
+func opStringConcat(c *CPU, in *instruction) error {
+	res, a, b := in.Args[0], in.Args[1], in.Args[2]
+
+	aVal, err := c.getString(a)
+	if err != nil {
+		return err
+	}
+	bVal, err := c.getString(b)
+	if err != nil {
+		return err
+	}
+	r, err := c.register(res)
+	if err != nil {
+		return err
+	}
+
+	// Ensure the result is permitted before building it.
+	err = c.checkString(res, len(aVal)+len(bVal))
+	if err != nil {
+		return err
+	}
+
+	r.SetString(aVal + bVal)
+	return nil
+}
+
+// opStringSystem runs the command held in a string register.
+func opStringSystem(c *CPU, in *instruction) error {
+	r := in.Args[0]
+
+	str, err := c.getString(r)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.runCommand(str, "")
+	if err != nil {
+		return err
+	}
+
+	// stdout
+	fmt.Printf("%s", res.stdout)
+
+	// stderr - if non-empty
+	if len(res.stderr) > 0 {
+		fmt.Printf("%s", res.stderr)
+	}
+	return nil
+}
+
+// opStringSystemCapture runs the command held in a string register,
+// storing its output and exit-status in registers rather than displaying
+// them.  The registers for STDERR, and STDIN, are optional.
+func opStringSystemCapture(c *CPU, in *instruction) error {
+	cmd, out, status, errout, input := in.Args[0], in.Args[1], in.Args[2], in.Args[3], in.Args[4]
+
+	for _, reg := range in.Args {
+		if reg != opcode.NoRegister {
+			_, err := c.register(reg)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	str, err := c.getString(cmd)
+	if err != nil {
+		return err
+	}
+
+	stdin := ""
+	if input != opcode.NoRegister {
+		stdin, err = c.getString(input)
+		if err != nil {
+			return err
+		}
+	}
+
+	res, err := c.runCommand(str, stdin)
+	if err != nil {
+		return err
+	}
+
+	err = c.setString(out, string(res.stdout))
+	if err != nil {
+		return err
+	}
+	err = c.setInt(status, res.status)
+	if err != nil {
+		return err
+	}
+	if errout != opcode.NoRegister {
+		return c.setString(errout, string(res.stderr))
+	}
+	return nil
+}
+
+// opStringToInt converts the string contents of a register to an integer.
+func opStringToInt(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	// get value
+	s, err := c.getString(reg)
+	if err != nil {
+		return err
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fault(ConversionFault, "Failed to convert '%s' to int: %s", s, err.Error())
+	}
+
+	return c.setInt(reg, i)
+}
+
+// opStringToIntBase converts the string contents of a register to an
+// integer, in the given base.
+func opStringToIntBase(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	s, err := c.getString(reg)
+	if err != nil {
+		return err
+	}
+
+	i, err := ParseInt(s, in.Args[1])
+	if err != nil {
+		return err
+	}
+	return c.setInt(reg, i)
+}
+
+// opStringLength stores the length of a string in a register.
+func opStringLength(c *CPU, in *instruction) error {
+	dst, src := in.Args[0], in.Args[1]
+
+	s, err := c.getString(src)
+	if err != nil {
+		return err
+	}
+	return c.setInt(dst, len(s))
+}
+
+// opStringSubstr stores part of a string in a register, given the
+// registers holding its start and length.
+func opStringSubstr(c *CPU, in *instruction) error {
+	dst, src, start, length := in.Args[0], in.Args[1], in.Args[2], in.Args[3]
+
+	s, err := c.getString(src)
+	if err != nil {
+		return err
+	}
+	from, err := c.getInt(start)
+	if err != nil {
+		return err
+	}
+	n, err := c.getInt(length)
+	if err != nil {
+		return err
+	}
+	return c.setString(dst, Substr(s, from, n))
+}
+
+// opStringIndex stores the position of one string within another, and
+// sets the Z-flag if it was found.  If it wasn't the position is -1.
+func opStringIndex(c *CPU, in *instruction) error {
+	dst, src, sub := in.Args[0], in.Args[1], in.Args[2]
+
+	s, err := c.getString(src)
+	if err != nil {
+		return err
+	}
+	needle, err := c.getString(sub)
+	if err != nil {
+		return err
+	}
+
+	i := strings.Index(s, needle)
+	c.flags = Flags{z: i >= 0}
+	return c.setInt(dst, i)
+}
+
+// opStringCharAt stores the byte at a position of a string in a register.
+func opStringCharAt(c *CPU, in *instruction) error {
+	dst, src, index := in.Args[0], in.Args[1], in.Args[2]
+
+	s, err := c.getString(src)
+	if err != nil {
+		return err
+	}
+	i, err := c.getInt(index)
+	if err != nil {
+		return err
+	}
+
+	ch, err := CharAt(s, i)
+	if err != nil {
+		return err
+	}
+	return c.setInt(dst, ch)
+}
+
+// opStringChr stores the character with the given value in a register.
+func opStringChr(c *CPU, in *instruction) error {
+	dst, src := in.Args[0], in.Args[1]
+
+	i, err := c.getInt(src)
+	if err != nil {
+		return err
+	}
+	return c.setString(dst, Chr(i))
+}
+
+// stringOp returns a handler which replaces the string contents of a
+// register with the result of applying fn to it.
+func stringOp(fn func(s string) string) handler {
+	return func(c *CPU, in *instruction) error {
+		reg := in.Args[0]
+
+		s, err := c.getString(reg)
+		if err != nil {
+			return err
+		}
+		return c.setString(reg, fn(s))
+	}
+}
+
+// opStringSplit splits a string at each occurrence of a separator,
+// pushing the pieces onto the stack so that the first is popped first,
+// and storing the number of pieces in a register.
+func opStringSplit(c *CPU, in *instruction) error {
+	count, src, sep := in.Args[0], in.Args[1], in.Args[2]
+
+	s, err := c.getString(src)
+	if err != nil {
+		return err
+	}
+	by, err := c.getString(sep)
+	if err != nil {
+		return err
+	}
+	_, err = c.register(count)
+	if err != nil {
+		return err
+	}
+
+	// The first piece is pushed last, so that it is popped first.
+	parts := Split(s, by)
+	values := make([]Object, len(parts))
+	for i, part := range parts {
+		values[len(parts)-1-i] = &StringObject{Value: part}
+	}
+	err = c.push(values...)
+	if err != nil {
+		return err
+	}
+	return c.setInt(count, len(parts))
+}
+
+//
+// Byte-array operations
+//
+
+// opBytesNew stores a byte-array in a register, with the number of zero
+// bytes held in another.
+func opBytesNew(c *CPU, in *instruction) error {
+	dst, length := in.Args[0], in.Args[1]
+
+	n, err := c.getInt(length)
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return fault(IndexFault, "Invalid length %d for a byte-array", n)
+	}
+	err = c.checkString(dst, n)
+	if err != nil {
+		return err
+	}
+
+	r, _ := c.register(dst)
+	r.SetObject(&BytesObject{Value: make([]byte, n)})
+	return nil
+}
+
+// byteIndex returns the given position of a byte-array, faulting if it is
+// outside the byte-array.
+func byteIndex(b *BytesObject, i int) (int, error) {
+	if i < 0 || i >= len(b.Value) {
+		return 0, fault(IndexFault, "Index %d is outside the byte-array of %d bytes", i, len(b.Value))
+	}
+	return i, nil
+}
+
+// opBytesGet stores the byte at a position of a byte-array in a register.
+func opBytesGet(c *CPU, in *instruction) error {
+	dst, src, index := in.Args[0], in.Args[1], in.Args[2]
+
+	b, err := c.getBytes(src)
+	if err != nil {
+		return err
+	}
+	i, err := c.getInt(index)
+	if err != nil {
+		return err
+	}
+	i, err = byteIndex(b, i)
+	if err != nil {
+		return err
+	}
+	return c.setInt(dst, int(b.Value[i]))
+}
+
+// opBytesSet sets the byte at a position of a byte-array, to the bottom
+// eight bits of an integer.
+func opBytesSet(c *CPU, in *instruction) error {
+	dst, index, src := in.Args[0], in.Args[1], in.Args[2]
+
+	b, err := c.getBytes(dst)
+	if err != nil {
+		return err
+	}
+	i, err := c.getInt(index)
+	if err != nil {
+		return err
+	}
+	v, err := c.getInt(src)
+	if err != nil {
+		return err
+	}
+	i, err = byteIndex(b, i)
+	if err != nil {
+		return err
+	}
+	b.Value[i] = byte(v)
+	return nil
+}
+
+// opBytesSlice stores a copy of part of a byte-array in a register, given
+// the registers holding its start and length.  The range is clipped to
+// the byte-array, as with `substr`.
+func opBytesSlice(c *CPU, in *instruction) error {
+	dst, src, start, length := in.Args[0], in.Args[1], in.Args[2], in.Args[3]
+
+	b, err := c.getBytes(src)
+	if err != nil {
+		return err
+	}
+	from, err := c.getInt(start)
+	if err != nil {
+		return err
+	}
+	n, err := c.getInt(length)
+	if err != nil {
+		return err
+	}
+
+	lo, hi := clip(len(b.Value), from, n)
+	err = c.checkString(dst, hi-lo)
+	if err != nil {
+		return err
+	}
+
+	r, _ := c.register(dst)
+	r.SetObject(&BytesObject{Value: append([]byte{}, b.Value[lo:hi]...)})
+	return nil
+}
+
+// opBytesAppend appends to a byte-array the contents of a register, which
+// may be an integer (appended as a single byte), a string, or another
+// byte-array.
+func opBytesAppend(c *CPU, in *instruction) error {
+	dst, src := in.Args[0], in.Args[1]
+
+	b, err := c.getBytes(dst)
+	if err != nil {
+		return err
+	}
+	r, err := c.register(src)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch v := r.o.(type) {
+	case *IntegerObject:
+		data = []byte{byte(v.Value)}
+	case *StringObject:
+		data = []byte(v.Value)
+	case *BytesObject:
+		data = v.Value
+	default:
+		return fault(TypeFault, "Register #%d cannot be appended to a byte-array", src)
+	}
+
+	err = c.checkString(dst, len(b.Value)+len(data))
+	if err != nil {
+		return err
+	}
+	b.Value = append(b.Value, data...)
+	return nil
+}
+
+// opBytesLength stores the length of a byte-array in a register.
+func opBytesLength(c *CPU, in *instruction) error {
+	dst, src := in.Args[0], in.Args[1]
+
+	b, err := c.getBytes(src)
+	if err != nil {
+		return err
+	}
+	return c.setInt(dst, len(b.Value))
+}
+
+// opBytesToString converts the byte-array contents of a register to a
+// string.
+func opBytesToString(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	b, err := c.getBytes(reg)
+	if err != nil {
+		return err
+	}
+	return c.setString(reg, string(b.Value))
+}
+
+// opStringToBytes converts the string contents of a register to a
+// byte-array.
+func opStringToBytes(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	s, err := c.getString(reg)
+	if err != nil {
+		return err
+	}
+
+	r, _ := c.register(reg)
+	r.SetObject(&BytesObject{Value: []byte(s)})
+	return nil
+}
+
+//
+// Map operations
+//
+
+// mapKey returns the contents of a register, which must be an integer or
+// a string, for use as the key of a map.
+func (c *CPU) mapKey(reg int) (interface{}, error) {
+	r, err := c.register(reg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := r.o.(type) {
+	case *IntegerObject:
+		return v.Value, nil
+	case *StringObject:
+		return v.Value, nil
+	}
+	return nil, fault(TypeFault, "Register #%d does not contain an integer or a string", reg)
+}
+
+// opMapNew stores an empty map in a register.
+func opMapNew(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	r, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+	r.SetObject(NewMapObject())
+	return nil
+}
+
+// opMapGet stores the value of a key of a map in a register, faulting if
+// the key isn't present.
+func opMapGet(c *CPU, in *instruction) error {
+	dst, src, k := in.Args[0], in.Args[1], in.Args[2]
+
+	m, err := c.getMap(src)
+	if err != nil {
+		return err
+	}
+	key, err := c.mapKey(k)
+	if err != nil {
+		return err
+	}
+
+	val, ok := m.Value[key]
+	if !ok {
+		return fault(KeyFault, "Key %#v is not present in the map", key)
+	}
+
+	r, err := c.register(dst)
+	if err != nil {
+		return err
+	}
+	if n, sized := size(val); sized {
+		err = c.checkString(dst, n)
+		if err != nil {
+			return err
+		}
+	}
+	r.SetObject(clone(val))
+	return nil
+}
+
+// opMapSet sets the value of a key of a map to the contents of a
+// register, which must be an integer or a string.
+func opMapSet(c *CPU, in *instruction) error {
+	dst, k, v := in.Args[0], in.Args[1], in.Args[2]
+
+	m, err := c.getMap(dst)
+	if err != nil {
+		return err
+	}
+	key, err := c.mapKey(k)
+	if err != nil {
+		return err
+	}
+
+	// The value must be an integer or a string too, it is stored as an
+	// object so that its type is kept.
+	_, err = c.mapKey(v)
+	if err != nil {
+		return err
+	}
+	r, _ := c.register(v)
+	obj := clone(r.o)
+
+	used := m.used + entrySize(key, obj)
+	if old, ok := m.Value[key]; ok {
+		used -= entrySize(key, old)
+	}
+	err = c.checkString(dst, used)
+	if err != nil {
+		return err
+	}
+
+	m.Set(key, obj)
+	return nil
+}
+
+// opMapDelete removes a key from a map, if it is present.
+func opMapDelete(c *CPU, in *instruction) error {
+	dst, k := in.Args[0], in.Args[1]
+
+	m, err := c.getMap(dst)
+	if err != nil {
+		return err
+	}
+	key, err := c.mapKey(k)
+	if err != nil {
+		return err
+	}
+
+	m.Delete(key)
+	return nil
+}
+
+// opMapHas sets the Z-flag if a map contains a key.
+func opMapHas(c *CPU, in *instruction) error {
+	src, k := in.Args[0], in.Args[1]
+
+	m, err := c.getMap(src)
+	if err != nil {
+		return err
+	}
+	key, err := c.mapKey(k)
+	if err != nil {
+		return err
+	}
+
+	_, ok := m.Value[key]
+	c.flags = Flags{z: ok}
+	return nil
+}
+
+// opMapKeys pushes the keys of a map onto the stack, and stores the
+// number of them in a register.
+//
+// The keys are sorted, with integers before strings, and pushed so that
+// the first is popped first.
+func opMapKeys(c *CPU, in *instruction) error {
+	count, src := in.Args[0], in.Args[1]
+
+	m, err := c.getMap(src)
+	if err != nil {
+		return err
+	}
+	_, err = c.register(count)
+	if err != nil {
+		return err
+	}
+
+	var ints []int
+	var strs []string
+	for key := range m.Value {
+		switch k := key.(type) {
+		case int:
+			ints = append(ints, k)
+		case string:
+			strs = append(strs, k)
+		}
+	}
+	sort.Ints(ints)
+	sort.Strings(strs)
+
+	// The integers are pushed last, so that they're popped first.
+	var values []Object
+	for i := len(strs) - 1; i >= 0; i-- {
+		values = append(values, &StringObject{Value: strs[i]})
+	}
+	for i := len(ints) - 1; i >= 0; i-- {
+		values = append(values, &IntegerObject{Value: ints[i]})
+	}
+	err = c.push(values...)
+	if err != nil {
+		return err
+	}
+	return c.setInt(count, len(m.Value))
+}
+
+//
+// Comparisons
+//
+
+// opCmpReg compares the contents of two registers.
+func opCmpReg(c *CPU, in *instruction) error {
+	r1, r2 := in.Args[0], in.Args[1]
+
+	c.flags = Flags{}
+
+	r, err := c.register(r1)
+	if err != nil {
+		return err
+	}
+
+	switch r.Type() {
+	case "int":
+		var val int
+		val, err = c.getInt(r2)
+		if err != nil {
+			return err
+		}
+		_, c.flags = c.width.Sub(r.GetInt(), val)
+	case "string":
+		var val string
+		val, err = c.getString(r2)
+		if err != nil {
+			return err
+		}
+		if r.GetString() == val {
+			c.flags.z = true
+		}
+	case "bytes":
+		var val *BytesObject
+		val, err = c.getBytes(r2)
+		if err != nil {
+			return err
+		}
+		c.flags.z = bytes.Equal(r.o.(*BytesObject).Value, val.Value)
+	}
+	return nil
+}
+
+// opCmpImmediate compares the contents of a register with a number.
+func opCmpImmediate(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	r, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	c.flags = Flags{}
+	if r.Type() == "int" {
+		_, c.flags = c.width.Sub(r.GetInt(), in.Args[1])
+	}
+	return nil
+}
+
+// opCmpString compares the contents of a register with a string.
+func opCmpString(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	r, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	c.flags = Flags{z: r.Type() == "string" && r.GetString() == in.Str}
+	return nil
+}
+
+// isType returns a handler which sets the Z-flag if the contents of a
+// register are of the given type.
+func isType(kind string) handler {
+	return func(c *CPU, in *instruction) error {
+		reg := in.Args[0]
+
+		r, err := c.register(reg)
+		if err != nil {
+			return err
+		}
+
+		c.flags.z = r.Type() == kind
+		return nil
+	}
+}
+
+// opRegStore copies the contents of one register to another.
+func opRegStore(c *CPU, in *instruction) error {
+	dst, src := in.Args[0], in.Args[1]
+
+	r, err := c.register(src)
+	if err != nil {
+		return err
+	}
+
+	// Copy the register - paying attention to types
+	switch r.Type() {
+	case "string":
+		return c.setString(dst, r.GetString())
+	case "int":
+		return c.setInt(dst, r.GetInt())
+	case "bytes", "map":
+		n, _ := size(r.o)
+		err = c.checkString(dst, n)
+		if err != nil {
+			return err
+		}
+		d, _ := c.register(dst)
+		d.SetObject(r.Object())
+		return nil
+	}
+	return fault(TypeFault, "Invalid register type?")
+}
+
+//
+// Memory operations
+//
+
+// opPeek reads a byte of RAM into a register.
+func opPeek(c *CPU, in *instruction) error {
+	result, src := in.Args[0], in.Args[1]
+
+	// get the address from the src register contents
+	addr, err := c.getInt(src)
+	if err != nil {
+		return err
+	}
+
+	// store the contents of the given address
+	data, err := c.loadMem(addr, 1)
+	if err != nil {
+		return err
+	}
+	return c.setInt(result, int(data[0]))
+}
+
+// opPoke writes a byte to RAM.
+func opPoke(c *CPU, in *instruction) error {
+	src, dst := in.Args[0], in.Args[1]
+
+	// So the destination will contain an address
+	// put the contents of the source to that.
+	addr, err := c.getInt(dst)
+	if err != nil {
+		return err
+	}
+	val, err := c.getInt(src)
+	if err != nil {
+		return err
+	}
+	err = checkValue(val, 1)
+	if err != nil {
+		return err
+	}
+
+	return c.storeMem(addr, []byte{byte(val)})
+}
+
+// opMemcpy copies a region of RAM.
+//
+// The region is read before it is written, so the copy is correct even
+// if the source and destination overlap.
+func opMemcpy(c *CPU, in *instruction) error {
+	dst, src, len := in.Args[0], in.Args[1], in.Args[2]
+
+	// get the addresses from the registers
+	srcAddr, err := c.getInt(src)
+	if err != nil {
+		return err
+	}
+	dstAddr, err := c.getInt(dst)
+	if err != nil {
+		return err
+	}
+	length, err := c.getInt(len)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.loadMem(srcAddr, length)
+	if err != nil {
+		return err
+	}
+	return c.storeMem(dstAddr, data)
+}
+
+// opPeekWord reads a two-byte word from RAM.
+func opPeekWord(c *CPU, in *instruction) error {
+	result, src := in.Args[0], in.Args[1]
+
+	addr, err := c.getInt(src)
+	if err != nil {
+		return err
+	}
+	data, err := c.loadMem(addr, 2)
+	if err != nil {
+		return err
+	}
+	return c.setInt(result, int(data[0])|int(data[1])<<8)
+}
+
+// opPokeWord writes the bottom sixteen bits of a register to RAM.
+func opPokeWord(c *CPU, in *instruction) error {
+	src, dst := in.Args[0], in.Args[1]
+
+	addr, err := c.getInt(dst)
+	if err != nil {
+		return err
+	}
+	val, err := c.getInt(src)
+	if err != nil {
+		return err
+	}
+	err = checkValue(val, 2)
+	if err != nil {
+		return err
+	}
+	return c.storeMem(addr, []byte{byte(val), byte(val >> 8)})
+}
+
+// opStoreMem writes a string to RAM, followed by a NUL byte.
+func opStoreMem(c *CPU, in *instruction) error {
+	src, dst := in.Args[0], in.Args[1]
+
+	addr, err := c.getInt(dst)
+	if err != nil {
+		return err
+	}
+	str, err := c.getString(src)
+	if err != nil {
+		return err
+	}
+	return c.storeMem(addr, append([]byte(str), 0))
+}
+
+// opLoadMem reads a string from RAM, either of the given length or up to
+// the first NUL byte.
+func opLoadMem(c *CPU, in *instruction) error {
+	result, src, length := in.Args[0], in.Args[1], in.Args[2]
+
+	if _, err := c.register(result); err != nil {
+		return err
+	}
+	addr, err := c.getInt(src)
+	if err != nil {
+		return err
+	}
+
+	if length == opcode.NoRegister {
+		var str string
+		str, err = c.loadString(addr)
+		if err != nil {
+			return err
+		}
+		return c.setString(result, str)
+	}
+
+	n, err := c.getInt(length)
+	if err != nil {
+		return err
+	}
+	data, err := c.loadMem(addr, n)
+	if err != nil {
+		return err
+	}
+	return c.setString(result, string(data))
+}
+
+// opMemset fills a region of RAM with the bottom eight bits of a
+// register.
+func opMemset(c *CPU, in *instruction) error {
+	dst, src, len := in.Args[0], in.Args[1], in.Args[2]
+
+	addr, err := c.getInt(dst)
+	if err != nil {
+		return err
+	}
+	val, err := c.getInt(src)
+	if err != nil {
+		return err
+	}
+	err = checkValue(val, 1)
+	if err != nil {
+		return err
+	}
+	length, err := c.getInt(len)
+	if err != nil {
+		return err
+	}
+	if length < 0 {
+		return fault(IndexFault, "Invalid length %d", length)
+	}
+	return c.storeMem(addr, bytes.Repeat([]byte{byte(val)}, length))
+}
+
+// opMemcmp compares two regions of RAM.
+//
+// The zero flag is set if they're equal, otherwise the flags are set as
+// `cmp` sets them for the first pair of bytes which differ.
+func opMemcmp(c *CPU, in *instruction) error {
+	r1, r2, len := in.Args[0], in.Args[1], in.Args[2]
+
+	one, err := c.getInt(r1)
+	if err != nil {
+		return err
+	}
+	two, err := c.getInt(r2)
+	if err != nil {
+		return err
+	}
+	length, err := c.getInt(len)
+	if err != nil {
+		return err
+	}
+
+	a, err := c.loadMem(one, length)
+	if err != nil {
+		return err
+	}
+	b, err := c.loadMem(two, length)
+	if err != nil {
+		return err
+	}
+
+	c.flags = Flags{z: true}
+	for i := range a {
+		if a[i] != b[i] {
+			_, c.flags = c.width.Sub(int(a[i]), int(b[i]))
+			break
+		}
+	}
+	return nil
+}
+
+//
+// Input
+//
+
+// opReadLine reads a line of input into a register.
+func opReadLine(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	_, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	var line string
+	line, c.flags = c.InputLine()
+	return c.setString(reg, line)
+}
+
+// opReadByte reads a byte of input into a register.
+func opReadByte(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	_, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	var b int
+	b, c.flags = c.InputByte()
+	return c.setInt(reg, b)
+}
+
+// opReadChar reads a character of input into a register.
+func opReadChar(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	_, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	var ch string
+	ch, c.flags = c.InputChar()
+	return c.setString(reg, ch)
+}
+
+// opReadInt reads a line of input into a register, as an integer.
+func opReadInt(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	_, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	var i int
+	i, c.flags = c.InputInt()
+	return c.setInt(reg, i)
+}
+
+//
+// Stack operations
+//
+
+// opPush pushes the contents of a register onto the stack.
+func opPush(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	r, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	// Store the contents of the register on the stack
+	return c.push(r.Object())
+}
+
+// opPop pops a value from the stack into a register.
+func opPop(c *CPU, in *instruction) error {
+	return c.pop(in.Args[0])
+}
+
+// pop pops a value from the stack into the given register.
+func (c *CPU) pop(reg int) error {
+	r, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	// Ensure our stack isn't empty
+	if c.stack.Empty() {
+		return fault(StackFault, "Stack Underflow!")
+	}
+
+	// Strings must fit within our limits, once they've left the stack.
+	val, _ := c.stack.Pop()
+	if n, ok := size(val); ok {
+		err = c.checkString(reg, n)
+		if err != nil {
+			c.stack.Push(val)
+			return err
+		}
+	}
+
+	// Restore the value, and type, of the register.
+	r.SetObject(val)
+	return nil
+}
+
+// opRet returns from a subroutine.
+func opRet(c *CPU, in *instruction) error {
+	// Ensure our call stack isn't empty
+	if len(c.calls) == 0 {
+		return fault(StackFault, "Return without a call")
+	}
+
+	// Get the frame of the caller
+	f := c.calls[len(c.calls)-1]
+	if f.irq {
+		return fault(StackFault, "Return from an interrupt via ret")
+	}
+	c.calls = c.calls[:len(c.calls)-1]
+
+	// restore the caller's frame-pointer, and jump
+	c.fp = f.fp
+	c.ip = f.ret
+	return nil
+}
+
+// opCall calls a subroutine.
+func opCall(c *CPU, in *instruction) error {
+	// ensure the call address is within RAM
+	err := c.checkMem(in.Args[0], 1)
+	if err != nil {
+		return err
+	}
+
+	// record the address of the next instruction on the call stack
+	err = c.call(in.Args[0], in.addr, in.next)
+	if err != nil {
+		return err
+	}
+
+	// jump to the call address
+	return c.jump(in.Args[0])
+}
+
+// opEnter begins a new stack-frame, reserving the given number of
+// entries upon the stack for local variables.
+func opEnter(c *CPU, in *instruction) error {
+	c.fp = c.stack.Size()
+	for i := 0; i < in.Args[0]; i++ {
+		err := c.push(&IntegerObject{Value: 0})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// opLeave discards the current stack-frame, and everything above it.
+func opLeave(c *CPU, in *instruction) error {
+	c.stack.Truncate(c.fp)
+	return nil
+}
+
+// frameOffset returns the offset within the stack of the given entry of
+// the current stack-frame.  Negative entries are below the frame, which
+// is where the arguments to a subroutine are found.
+func (c *CPU) frameOffset(entry int) int {
+	return c.fp + int(int16(entry))
+}
+
+// opLoad copies an entry of the current stack-frame to a register.
+func opLoad(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	r, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	val, err := c.stack.Get(c.frameOffset(in.Args[1]))
+	if err != nil {
+		return fault(StackFault, "%s", err.Error())
+	}
+	if n, ok := size(val); ok {
+		err = c.checkString(reg, n)
+		if err != nil {
+			return err
+		}
+	}
+
+	r.SetObject(clone(val))
+	return nil
+}
+
+// opSave copies a register to an entry of the current stack-frame.
+func opSave(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	r, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+
+	offset := c.frameOffset(in.Args[1])
+	old, err := c.stack.Get(offset)
+	if err != nil {
+		return fault(StackFault, "%s", err.Error())
+	}
+	val := r.Object()
+	n, _ := size(val)
+	m, _ := size(old)
+	err = c.checkStrings(-1, n-m)
+	if err != nil {
+		return err
+	}
+
+	err = c.stack.Set(offset, val)
+	if err != nil {
+		return fault(StackFault, "%s", err.Error())
+	}
+	return nil
+}
+
+// opGetSP stores the depth of the stack in a register.
+func opGetSP(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	return c.setInt(reg, c.stack.Size())
+}
+
+// opSetSP sets the depth of the stack from a register, discarding entries
+// or adding zeros as required.
+func opSetSP(c *CPU, in *instruction) error {
+	reg := in.Args[0]
+
+	depth, err := c.getInt(reg)
+	if err != nil {
+		return err
+	}
+
+	c.stack.Truncate(depth)
+	for c.stack.Size() < depth {
+		err = c.push(&IntegerObject{Value: 0})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// opTrap invokes a trap-function.
+func opTrap(c *CPU, in *instruction) error {
+	num := in.Args[0]
+
+	fn := TRAPS[num]
+	if c.traps != nil && !c.traps[num] {
+		fn = TrapNOP
+	}
+	if fn == nil {
+		return nil
+	}
+
+	// Faults raised by the trap are reported as trap-faults,
+	// unless the trap was more specific.
+	err := fn(c, num)
+	if err != nil {
+		if _, ok := err.(*Fault); !ok {
+			err = fault(TrapFault, "%s", err.Error())
+		}
+	}
+	return err
+}
+
+func init() {
+	handlers[opcode.EXIT] = opExit
+	handlers[opcode.EXIT_IMMEDIATE] = opExitImmediate
+	handlers[opcode.EXIT_REG] = opExitReg
+
+	handlers[opcode.INT_STORE] = opIntStore
+	handlers[opcode.INT_STORE_WIDE] = opIntStore
+	handlers[opcode.INT_PRINT] = opIntPrint
+	handlers[opcode.INT_PRINT_FMT] = opIntPrintFmt
+	handlers[opcode.INT_TOSTRING] = opIntToString
+	handlers[opcode.INT_RANDOM] = opIntRandom
+
+	handlers[opcode.JUMP_TO] = opJump
+	handlers[opcode.JUMP_Z] = jumpIf(func(f Flags, w Width) bool { return f.Zero() })
+	handlers[opcode.JUMP_NZ] = jumpIf(func(f Flags, w Width) bool { return !f.Zero() })
+	handlers[opcode.JUMP_L] = jumpIf(func(f Flags, w Width) bool { return f.Less(w) })
+	handlers[opcode.JUMP_G] = jumpIf(func(f Flags, w Width) bool { return !f.Less(w) && !f.Zero() })
+	handlers[opcode.JUMP_LE] = jumpIf(func(f Flags, w Width) bool { return f.Less(w) || f.Zero() })
+	handlers[opcode.JUMP_GE] = jumpIf(func(f Flags, w Width) bool { return !f.Less(w) })
+	handlers[opcode.JUMP_C] = jumpIf(func(f Flags, w Width) bool { return f.Carry() })
+	handlers[opcode.JUMP_NC] = jumpIf(func(f Flags, w Width) bool { return !f.Carry() })
+
+	math := map[int]mathFunc{
+		opcode.XOR_OP: func(w Width, a, b int) (int, Flags) { return w.Logic(a ^ b) },
+		opcode.ADD_OP: Width.Add,
+		opcode.SUB_OP: Width.Sub,
+		opcode.MUL_OP: Width.Mul,
+		opcode.AND_OP: func(w Width, a, b int) (int, Flags) { return w.Logic(a & b) },
+		opcode.OR_OP:  func(w Width, a, b int) (int, Flags) { return w.Logic(a | b) },
+		opcode.SHL_OP: Width.Shl,
+		opcode.SHR_OP: Width.Shr,
+		opcode.SAR_OP: Width.Sar,
+		opcode.ROL_OP: Width.Rol,
+		opcode.ROR_OP: Width.Ror,
+	}
+	for op, fn := range math {
+		handlers[op] = mathOp(fn, false)
+		handlers[op+opcode.IMMEDIATE] = mathOp(fn, true)
+		handlers[op+opcode.IMMEDIATE_SHORT] = mathOp(fn, true)
+	}
+	for op, fn := range map[int]mathFunc{opcode.DIV_OP: Width.Div, opcode.MOD_OP: Width.Mod} {
+		handlers[op] = divideOp(fn, false)
+		handlers[op+opcode.IMMEDIATE] = divideOp(fn, true)
+		handlers[op+opcode.IMMEDIATE_SHORT] = divideOp(fn, true)
+	}
+	handlers[opcode.INC_OP] = unaryOp(func(w Width, a int) (int, Flags) { return w.Add(a, 1) })
+	handlers[opcode.DEC_OP] = unaryOp(func(w Width, a int) (int, Flags) { return w.Sub(a, 1) })
+	handlers[opcode.NOT_OP] = unaryOp(Width.Not)
+	handlers[opcode.NEG_OP] = unaryOp(Width.Neg)
+
+	handlers[opcode.STRING_STORE] = opStringStore
+	handlers[opcode.STRING_PRINT] = opStringPrint
+	handlers[opcode.STRING_CONCAT] = opStringConcat
+	handlers[opcode.STRING_SYSTEM] = opStringSystem
+	handlers[opcode.STRING_SYSTEM_CAPTURE] = opStringSystemCapture
+	handlers[opcode.STRING_TOINT] = opStringToInt
+	handlers[opcode.STRING_TOINT_BASE] = opStringToIntBase
+	handlers[opcode.STRING_LENGTH] = opStringLength
+	handlers[opcode.STRING_SUBSTR] = opStringSubstr
+	handlers[opcode.STRING_INDEX] = opStringIndex
+	handlers[opcode.STRING_CHARAT] = opStringCharAt
+	handlers[opcode.STRING_CHR] = opStringChr
+	handlers[opcode.STRING_UPPER] = stringOp(strings.ToUpper)
+	handlers[opcode.STRING_LOWER] = stringOp(strings.ToLower)
+	handlers[opcode.STRING_TRIM] = stringOp(strings.TrimSpace)
+	handlers[opcode.STRING_SPLIT] = opStringSplit
+
+	handlers[opcode.CMP_REG] = opCmpReg
+	handlers[opcode.CMP_IMMEDIATE] = opCmpImmediate
+	handlers[opcode.CMP_IMMEDIATE_WIDE] = opCmpImmediate
+	handlers[opcode.CMP_STRING] = opCmpString
+	handlers[opcode.IS_STRING] = isType("string")
+	handlers[opcode.IS_INTEGER] = isType("int")
+	handlers[opcode.IS_BYTES] = isType("bytes")
+	handlers[opcode.IS_MAP] = isType("map")
+
+	handlers[opcode.NOP_OP] = opNop
+	handlers[opcode.REG_STORE] = opRegStore
+
+	handlers[opcode.PEEK] = opPeek
+	handlers[opcode.POKE] = opPoke
+	handlers[opcode.MEMCPY] = opMemcpy
+	handlers[opcode.PEEK_WORD] = opPeekWord
+	handlers[opcode.POKE_WORD] = opPokeWord
+	handlers[opcode.STORE_MEM] = opStoreMem
+	handlers[opcode.LOAD_MEM] = opLoadMem
+	handlers[opcode.MEMSET] = opMemset
+	handlers[opcode.MEMCMP] = opMemcmp
+
+	handlers[opcode.INT_ENABLE] = opIntEnable
+	handlers[opcode.INT_DISABLE] = opIntDisable
+	handlers[opcode.INT_RET] = opIntRet
+
+	handlers[opcode.STACK_PUSH] = opPush
+	handlers[opcode.STACK_POP] = opPop
+	handlers[opcode.STACK_RET] = opRet
+	handlers[opcode.STACK_CALL] = opCall
+	handlers[opcode.STACK_ENTER] = opEnter
+	handlers[opcode.STACK_LEAVE] = opLeave
+	handlers[opcode.STACK_LOAD] = opLoad
+	handlers[opcode.STACK_SAVE] = opSave
+	handlers[opcode.STACK_GETSP] = opGetSP
+	handlers[opcode.STACK_SETSP] = opSetSP
+
+	handlers[opcode.TRAP_OP] = opTrap
+
+	handlers[opcode.READ_LINE] = opReadLine
+	handlers[opcode.READ_BYTE] = opReadByte
+	handlers[opcode.READ_CHAR] = opReadChar
+	handlers[opcode.READ_INT] = opReadInt
+
+	handlers[opcode.BYTES_NEW] = opBytesNew
+	handlers[opcode.BYTES_GET] = opBytesGet
+	handlers[opcode.BYTES_SET] = opBytesSet
+	handlers[opcode.BYTES_SLICE] = opBytesSlice
+	handlers[opcode.BYTES_APPEND] = opBytesAppend
+	handlers[opcode.BYTES_LENGTH] = opBytesLength
+	handlers[opcode.BYTES_TOSTRING] = opBytesToString
+	handlers[opcode.STRING_TOBYTES] = opStringToBytes
+
+	handlers[opcode.MAP_NEW] = opMapNew
+	handlers[opcode.MAP_GET] = opMapGet
+	handlers[opcode.MAP_SET] = opMapSet
+	handlers[opcode.MAP_DELETE] = opMapDelete
+	handlers[opcode.MAP_HAS] = opMapHas
+	handlers[opcode.MAP_KEYS] = opMapKeys
+}
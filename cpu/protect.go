@@ -0,0 +1,81 @@
+// This file contains the protection of memory, which prevents a program
+// from writing over its own code, or executing its data.
+//
+// A program may describe regions of memory, each of which may be read,
+// written or executed only if its permissions allow - see the regions
+// section of the header in opcode/header.go.  Memory outside a region
+// may be accessed in any way, so a program without regions is entirely
+// unprotected.  Any access which isn't permitted faults with a
+// ProtectionFault.
+//
+// Protection may be disabled via SetPermissive, for programs which
+// modify themselves deliberately.
+
+package cpu
+
+import (
+	"fmt"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// SetPermissive disables the protection of memory if permissive is true,
+// allowing the program to access any address in any way.
+func (c *CPU) SetPermissive(permissive bool) {
+	c.permissive = permissive
+}
+
+// Protect sets the permissions of a region of memory, see opcode.PermRead
+// and friends.  The region must lie within RAM.
+func (c *CPU) Protect(r opcode.Region) error {
+	if r.Start < 0 || r.Start > r.End || r.End > len(c.mem) {
+		return fmt.Errorf("region %s is outside RAM", r)
+	}
+	if c.perms == nil {
+		c.perms = make([]byte, len(c.mem))
+		for i := range c.perms {
+			c.perms[i] = opcode.PermAll
+		}
+	}
+	for i := r.Start; i < r.End; i++ {
+		c.perms[i] = r.Perm
+	}
+	return nil
+}
+
+// checkPerm tests that the n bytes beginning at addr, which must lie
+// within RAM, may be accessed as perm allows.
+func (c *CPU) checkPerm(addr int, n int, perm byte) error {
+	if c.perms == nil || c.permissive {
+		return nil
+	}
+	for i := addr; i < addr+n; i++ {
+		if c.perms[i]&perm == 0 {
+			return fault(ProtectionFault, "Address %04X is not %s", i, permNames[perm])
+		}
+	}
+	return nil
+}
+
+// checkExec tests that the n bytes of the instruction at addr may be
+// executed, which they may not if they lie within a device.  As the IP
+// does, an instruction at the end of RAM wraps around to the start.
+func (c *CPU) checkExec(addr int, n int) error {
+	for i := 0; i < n; i++ {
+		a := (addr + i) % len(c.mem)
+		if c.devices != nil && c.device(a) != nil {
+			return fault(ProtectionFault, "Address %04X is not %s", a, permNames[opcode.PermExec])
+		}
+		if err := c.checkPerm(a, 1, opcode.PermExec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// permNames describes each permission, in the messages of faults.
+var permNames = map[byte]string{
+	opcode.PermRead:  "readable",
+	opcode.PermWrite: "writable",
+	opcode.PermExec:  "executable",
+}
@@ -0,0 +1,109 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/skx/go.vm/opcode"
+)
+
+// Test that the sections a program protects fault when they're accessed
+// in a way their permissions don't allow, unless permissive.
+func TestProtect(t *testing.T) {
+
+	tests := []struct {
+		src string
+		ok  bool
+	}{
+		// Code may be read, but not written.
+		{`section "rx"
+        store #1, 0
+        peek #2, #1
+        exit`, true},
+		{`section "rx"
+        store #1, 0
+        poke #1, #1
+        exit`, false},
+
+		// Data may be written, but not executed.
+		{`section "rx"
+        store #1, data
+        store #2, 0x50
+        poke #2, #1
+        goto data
+        section "rw"
+:data
+        DB 0x50, 0x00`, false},
+
+		// Memory beyond the program.
+		{`section "r", 0x5000, 0x6000
+        store #1, 0x5000
+        peek #2, #1
+        exit`, true},
+		{`section "r", 0x5000, 0x6000
+        store #1, 0x5FFF
+        pokew #1, #1
+        exit`, false},
+		{`section "w", 0x5000, 0x6000
+        store #1, 0x4FFF
+        store #2, 2
+        load_mem #3, #1, #2
+        exit`, false},
+	}
+
+	for _, test := range tests {
+		for _, permissive := range []bool{false, true} {
+			c := NewCPU()
+			c.SetPermissive(permissive)
+			c.LoadBytes(compile(t, test.src))
+
+			_, err := c.Run()
+			if permissive || test.ok {
+				if err != nil {
+					t.Errorf("unexpected error running %q: %s", test.src, err.Error())
+				}
+				continue
+			}
+			if f, ok := err.(*Fault); !ok || f.Kind != ProtectionFault {
+				t.Errorf("expected a protection fault running %q, got %v", test.src, err)
+			}
+		}
+	}
+}
+
+// Test that every byte of an instruction must be executable, not just its
+// opcode.
+func TestProtectOperands(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+        section "rx"
+        DB 0x01
+        section "rw"
+        DB 0x01, 0x05, 0x00
+        exit`))
+
+	_, err := c.Run()
+	if f, ok := err.(*Fault); !ok || f.Kind != ProtectionFault || f.IP != 0 {
+		t.Errorf("expected a protection fault at 0000, got %v", err)
+	}
+	if c.regs[1].GetInt() != 0 {
+		t.Errorf("the instruction was executed")
+	}
+}
+
+// Test that regions are applied in order, and must lie within RAM.
+func TestProtectRegions(t *testing.T) {
+	c := NewCPU()
+
+	if c.Protect(opcode.Region{Start: 0, End: DefaultMemory + 1}) == nil {
+		t.Errorf("expected a region outside RAM to be rejected")
+	}
+
+	c.Protect(opcode.Region{Start: 0x10, End: 0x20, Perm: opcode.PermRead})
+	c.Protect(opcode.Region{Start: 0x18, End: 0x20, Perm: opcode.PermAll})
+	if c.checkPerm(0x17, 1, opcode.PermWrite) == nil {
+		t.Errorf("expected a read-only address to be protected")
+	}
+	if c.checkPerm(0x18, 8, opcode.PermWrite) != nil || c.checkPerm(0x20, 1, opcode.PermExec) != nil {
+		t.Errorf("expected a later region to take precedence")
+	}
+}
@@ -29,9 +29,65 @@ type StringObject struct {
 // Type returns `string` for StringObjects.
 func (i *StringObject) Type() string { return "string" }
 
+// BytesObject is an object holding an array of bytes, which unlike a
+// string may be modified in-place.
+type BytesObject struct {
+	Value []byte
+}
+
+// Type returns `bytes` for BytesObjects.
+func (i *BytesObject) Type() string { return "bytes" }
+
+// MapObject is an object holding a map, whose keys are integers or
+// strings, and whose values are IntegerObjects or StringObjects.  The
+// integer 1 and the string "1" are different keys.
+type MapObject struct {
+	Value map[interface{}]Object
+
+	// The size of the entries, as counted by the limits.
+	used int
+}
+
+// Type returns `map` for MapObjects.
+func (i *MapObject) Type() string { return "map" }
+
+// NewMapObject returns an empty map.
+func NewMapObject() *MapObject {
+	return &MapObject{Value: make(map[interface{}]Object)}
+}
+
+// Set stores a value in the map.
+func (i *MapObject) Set(key interface{}, val Object) {
+	i.Delete(key)
+	i.Value[key] = val
+	i.used += entrySize(key, val)
+}
+
+// Delete removes a key, and its value, from the map.
+func (i *MapObject) Delete(key interface{}) {
+	if val, ok := i.Value[key]; ok {
+		i.used -= entrySize(key, val)
+		delete(i.Value, key)
+	}
+}
+
+// entrySize returns the number of bytes an entry of a map counts as when
+// the limits are applied: the length of its strings, plus one.
+func entrySize(key interface{}, val Object) int {
+	n := 1
+	if s, ok := key.(string); ok {
+		n += len(s)
+	}
+	if s, ok := val.(*StringObject); ok {
+		n += len(s.Value)
+	}
+	return n
+}
+
 // Register holds the contents of a single register, as an object.
 //
-// This means it can hold either an IntegerObject, or a StringObject.
+// This means it can hold an IntegerObject, a StringObject, a BytesObject,
+// or a MapObject.
 type Register struct {
 	o Object
 }
@@ -58,15 +114,18 @@ func (r *Register) GetInt() int {
 }
 
 // SetInt stores the given integer in the register.
-// Note that a register may only contain integers in the range 0x0000-0xffff
+//
+// The value is stored as-is, the CPU wraps values to the width of its
+// integers before storing them - see width.go.
+//
+// If the register already holds an integer it is updated in-place, to
+// avoid an allocation.
 func (r *Register) SetInt(v int) {
-	if v <= 0 {
-		r.o = &IntegerObject{Value: 0}
-	} else if v >= 0xffff {
-		r.o = &IntegerObject{Value: 0xffff}
-	} else {
-		r.o = &IntegerObject{Value: v}
+	if i, ok := r.o.(*IntegerObject); ok {
+		i.Value = v
+		return
 	}
+	r.o = &IntegerObject{Value: v}
 }
 
 // GetString retrieves the string content of the given register.
@@ -89,7 +148,120 @@ func (r *Register) SetString(v string) {
 	r.o = &StringObject{Value: v}
 }
 
-// Type returns the type of a registers contents `int` vs. `string`.
+// Object returns the contents of the register.
+//
+// The object is a copy, so it is unaffected by further changes to the
+// register.
+func (r *Register) Object() Object {
+	return clone(r.o)
+}
+
+// SetObject stores the given object in the register.
+func (r *Register) SetObject(o Object) {
+	r.o = o
+}
+
+// clone returns a copy of the given object.
+//
+// Integers are updated in-place by SetInt, byte-arrays by `setbyte` and
+// `append`, and maps by `mapset` and `mapdel`, so they must be copied when
+// they're shared.  Strings are never modified, so they may be shared.
+func clone(o Object) Object {
+	switch v := o.(type) {
+	case *IntegerObject:
+		return &IntegerObject{Value: v.Value}
+	case *BytesObject:
+		return &BytesObject{Value: append([]byte{}, v.Value...)}
+	case *MapObject:
+		m := NewMapObject()
+		for key, val := range v.Value {
+			m.Set(key, clone(val))
+		}
+		return m
+	}
+	return o
+}
+
+// Type returns the type of a registers contents `int`, `string`, `bytes`,
+// or `map`.
 func (r *Register) Type() string {
 	return (r.o.Type())
 }
+
+// register returns the given register, faulting if it doesn't exist.
+//
+// Opcodes must access registers via this function, or the helpers which
+// use it, so that invalid registers are always reported.
+func (c *CPU) register(reg int) (*Register, error) {
+	if reg < 0 || reg >= len(c.regs) {
+		return nil, fault(RegisterFault, "Register %d out of range", reg)
+	}
+	return c.regs[reg], nil
+}
+
+// getInt returns the integer contents of the given register, faulting if
+// the register doesn't exist or holds something else.
+func (c *CPU) getInt(reg int) (int, error) {
+	r, err := c.register(reg)
+	if err != nil {
+		return 0, err
+	}
+	if i, ok := r.o.(*IntegerObject); ok {
+		return i.Value, nil
+	}
+	return 0, fault(TypeFault, "Register #%d does not contain an integer", reg)
+}
+
+// getString returns the string contents of the given register, faulting
+// if the register doesn't exist or holds something else.
+func (c *CPU) getString(reg int) (string, error) {
+	r, err := c.register(reg)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := r.o.(*StringObject); ok {
+		return s.Value, nil
+	}
+	return "", fault(TypeFault, "Register #%d does not contain a string", reg)
+}
+
+// getBytes returns the byte-array held in the given register, faulting
+// if the register doesn't exist or holds something else.
+//
+// The byte-array is not a copy, so changes to it are seen by the register.
+func (c *CPU) getBytes(reg int) (*BytesObject, error) {
+	r, err := c.register(reg)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := r.o.(*BytesObject); ok {
+		return b, nil
+	}
+	return nil, fault(TypeFault, "Register #%d does not contain a byte-array", reg)
+}
+
+// getMap returns the map held in the given register, faulting if the
+// register doesn't exist or holds something else.
+//
+// The map is not a copy, so changes to it are seen by the register.
+func (c *CPU) getMap(reg int) (*MapObject, error) {
+	r, err := c.register(reg)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := r.o.(*MapObject); ok {
+		return m, nil
+	}
+	return nil, fault(TypeFault, "Register #%d does not contain a map", reg)
+}
+
+// setInt stores an integer in the given register, wrapped to the width of
+// our integers, faulting if the register doesn't exist.
+func (c *CPU) setInt(reg int, val int) error {
+	r, err := c.register(reg)
+	if err != nil {
+		return err
+	}
+	r.SetInt(c.width.Wrap(val))
+	return nil
+}
@@ -44,31 +44,41 @@ func TestRegisterString(t *testing.T) {
 // Test overflow
 func TestIntBounds(t *testing.T) {
 
-	// We expect 0-0xffff
+	// We expect values to wrap to the width of the CPU
 	type TestCase struct {
-		set int
-		get int
+		width Width
+		set   int
+		get   int
 	}
 
 	// Test some negative & excessive values
 	tests := []TestCase{
-		{-100, 0},
-		{-1, 0},
-		{0, 0},
-		{1, 1},
-		{0xffff, 0xffff},
-		{0xffffff, 0xffff},
+		{Width16, -100, 0xFF9C},
+		{Width16, -1, 0xffff},
+		{Width16, 0, 0},
+		{Width16, 1, 1},
+		{Width16, 0xffff, 0xffff},
+		{Width16, 0x10000, 0},
+		{Width16, 0xffffff, 0xffff},
+		{Width32, -1, -1},
+		{Width32, 0xffff, 0xffff},
+		{Width32, 0x7fffffff + 1, -0x80000000},
+		{Width32, 0xffffffff, -1},
+		{Width64, -1, -1},
+		{Width64, 0xffffffff, 0xffffffff},
 	}
 
 	for _, test := range tests {
-		r := NewRegister()
-		r.SetInt(test.set)
+		c := NewCPU()
+		c.SetWidth(test.width)
+		c.setInt(0, test.set)
 
+		r := c.Registers()[0]
 		if r.Type() != "int" {
 			t.Errorf("register is not an int")
 		}
 		if r.GetInt() != test.get {
-			t.Errorf("register contains the wrong value: 0x%04X != 0x%04X", r.GetInt(), test.get)
+			t.Errorf("%d-bit register contains the wrong value: 0x%04X != 0x%04X", test.width, r.GetInt(), test.get)
 		}
 	}
 }
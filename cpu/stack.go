@@ -1,16 +1,28 @@
-// This file contains the implementation of the stack the CPU uses.
+// This file contains the implementation of the data stack the CPU uses.
 //
-// Note that the stack is used for storing integers only.
+// The stack holds objects, so integers, strings, byte-arrays and maps may
+// all be stored upon it.  Return-addresses are kept separately, see
+// calls.go.
 
 package cpu
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
-// Stack holds return-addresses when the `call` operation is being
-// completed.  It can also be used for storing ints.
+// Stack holds the values pushed by `push`, and the local variables of
+// stack-frames created by `enter`.
+//
+// The stack is last-in, first-out, so values are popped in the reverse
+// of the order in which they were pushed.
 type Stack struct {
-	// The entries on our stack
-	entries []int
+	// The entries on our stack, the top of the stack is last.
+	entries []Object
+
+	// bytes is the total size of the strings, byte-arrays, and maps
+	// held on the stack.
+	bytes int
 }
 
 //
@@ -32,18 +44,84 @@ func (s *Stack) Size() int {
 	return (len(s.entries))
 }
 
-// Push adds a value to the stack.
-func (s *Stack) Push(value int) {
+// Depth returns the number of entries on the stack, as Size does.
+func (s *Stack) Depth() int {
+	return s.Size()
+}
+
+// Bytes returns the total size of the strings, byte-arrays, and maps
+// held on the stack.
+func (s *Stack) Bytes() int {
+	return s.bytes
+}
+
+// Push adds a value to the top of the stack.
+func (s *Stack) Push(value Object) {
+	n, _ := size(value)
+	s.bytes += n
 	s.entries = append(s.entries, value)
 }
 
-// Pop removes a value from the stack.
-func (s *Stack) Pop() (int, error) {
+// Pop removes the value from the top of the stack.
+func (s *Stack) Pop() (Object, error) {
 	if s.Empty() {
-		return 0, errors.New("Pop from an empty stack")
+		return nil, errors.New("Pop from an empty stack")
 	}
 
-	result := s.entries[0]
-	s.entries = append(s.entries[:0], s.entries[1:]...)
+	top := len(s.entries) - 1
+	result := s.entries[top]
+	s.entries[top] = nil
+	s.entries = s.entries[:top]
+	n, _ := size(result)
+	s.bytes -= n
 	return result, nil
 }
+
+// Peek returns the value at the top of the stack, without removing it.
+func (s *Stack) Peek() (Object, error) {
+	if s.Empty() {
+		return nil, errors.New("Peek at an empty stack")
+	}
+	return s.entries[len(s.entries)-1], nil
+}
+
+// Get returns the entry at the given offset from the bottom of the stack.
+func (s *Stack) Get(offset int) (Object, error) {
+	if offset < 0 || offset >= len(s.entries) {
+		return nil, fmt.Errorf("Stack offset %d out of range", offset)
+	}
+	return s.entries[offset], nil
+}
+
+// Set replaces the entry at the given offset from the bottom of the stack.
+func (s *Stack) Set(offset int, value Object) error {
+	if offset < 0 || offset >= len(s.entries) {
+		return fmt.Errorf("Stack offset %d out of range", offset)
+	}
+	old, _ := size(s.entries[offset])
+	n, _ := size(value)
+	s.bytes += n - old
+	s.entries[offset] = value
+	return nil
+}
+
+// Truncate removes entries from the top of the stack, until no more than
+// the given number remain.
+func (s *Stack) Truncate(depth int) {
+	if depth < 0 {
+		depth = 0
+	}
+	for len(s.entries) > depth {
+		s.Pop()
+	}
+}
+
+// Snapshot returns a copy of the entries on the stack, from the bottom
+// of the stack to the top.
+//
+// This is intended for debuggers, the objects must not be modified.
+func (s *Stack) Snapshot() []Object {
+	out := make([]Object, len(s.entries))
+	copy(out, s.entries)
+	return out
+}
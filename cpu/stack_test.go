@@ -1,57 +1,290 @@
 package cpu
 
 import (
+	"reflect"
 	"testing"
 )
 
-// Test a new stack is empty
-func TestStackStartsEmpty(t *testing.T) {
+// Test that values are popped in the reverse order to which they were
+// pushed.
+func TestStackOrder(t *testing.T) {
 	s := NewStack()
+
+	if !s.Empty() || s.Size() != 0 || s.Depth() != 0 {
+		t.Fatalf("new stack is not empty")
+	}
+	if _, err := s.Pop(); err == nil {
+		t.Errorf("expected an error popping from an empty stack")
+	}
+	if _, err := s.Peek(); err == nil {
+		t.Errorf("expected an error peeking at an empty stack")
+	}
+
+	s.Push(&IntegerObject{Value: 1})
+	s.Push(&StringObject{Value: "two"})
+	s.Push(&IntegerObject{Value: 3})
+	if s.Size() != 3 || s.Depth() != 3 {
+		t.Errorf("unexpected depth %d", s.Depth())
+	}
+
+	snap := s.Snapshot()
+	if !reflect.DeepEqual(snap, []Object{&IntegerObject{Value: 1}, &StringObject{Value: "two"}, &IntegerObject{Value: 3}}) {
+		t.Errorf("unexpected snapshot %v", snap)
+	}
+	snap[0] = nil
+
+	top, err := s.Peek()
+	if err != nil || top.Type() != "int" || s.Size() != 3 {
+		t.Errorf("unexpected peek %v %v, depth %d", top, err, s.Size())
+	}
+
+	for _, expected := range []string{"int", "string", "int"} {
+		v, perr := s.Pop()
+		if perr != nil {
+			t.Fatalf("unexpected error: %s", perr.Error())
+		}
+		if v.Type() != expected {
+			t.Errorf("expected %s, got %s", expected, v.Type())
+		}
+	}
 	if !s.Empty() {
-		t.Errorf("New stack is non-empty")
+		t.Errorf("stack is not empty")
 	}
-	if s.Size() != 0 {
-		t.Errorf("New stack is non-empty")
+}
+
+// Test that nested subroutines return to the correct place.
+func TestNestedCall(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+        store #1, 0
+        store #2, 1
+        call one
+        add #1, #1, #2
+        exit
+:one
+        call two
+        add #1, #1, #1
+        ret
+:two
+        call three
+        add #1, #1, #2
+        ret
+:three
+        store #1, 10
+        ret
+`))
+
+	_, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// ((10 + 1) * 2) + 1
+	if c.regs[1].GetInt() != 23 {
+		t.Errorf("unexpected result %d", c.regs[1].GetInt())
+	}
+	if c.stack.Size() != 0 {
+		t.Errorf("stack not empty: %v", c.stack.Snapshot())
 	}
 }
 
-// Test we can add/remove a value
-func TestStack(t *testing.T) {
-	s := NewStack()
+// Test that values pushed around subroutine calls are preserved, and
+// that subroutines may use the stack themselves.
+func TestMixedPushCall(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+        store #1, 1
+        store #2, 2
+        push #1
+        push #2
+        call keep
+        pop #3
+        pop #4
+        exit
+:keep
+        store #1, 100
+        push #1
+        store #1, 200
+        push #1
+        pop #5
+        pop #6
+        ret
+`))
 
-	s.Push(42)
+	_, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
 
-	if s.Empty() {
-		t.Errorf("Stack should not be empty after adding item.")
+	expected := map[int]int{3: 2, 4: 1, 5: 200, 6: 100}
+	for reg, val := range expected {
+		if c.regs[reg].GetInt() != val {
+			t.Errorf("register #%d holds %d, expected %d", reg, c.regs[reg].GetInt(), val)
+		}
 	}
-	if s.Size() != 1 {
-		t.Errorf("stack has a size-mismatch")
+}
+
+// Test that strings may be saved on the stack, and are restored with
+// their original type.
+func TestPushString(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+        store #1, "saved"
+        store #2, 42
+        push #1
+        push #2
+        call clobber
+        pop #2
+        pop #1
+        exit
+:clobber
+        store #1, 1
+        store #2, "clobbered"
+        ret
+`))
+
+	_, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if c.regs[1].Type() != "string" || c.regs[1].GetString() != "saved" {
+		t.Errorf("unexpected contents of #1: %v", c.regs[1].o)
+	}
+	if c.regs[2].Type() != "int" || c.regs[2].GetInt() != 42 {
+		t.Errorf("unexpected contents of #2: %v", c.regs[2].o)
+	}
+}
+
+// Test that a subroutine which leaves values on the stack still returns
+// to the right place, and that returning without a call faults.
+func TestRetFault(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+        call routine
+        store #2, 1
+        ret
+:routine
+        store #1, "oops"
+        push #1
+        ret
+`))
+
+	_, err := c.Run()
+	f, ok := err.(*Fault)
+	if !ok || f.Kind != StackFault {
+		t.Fatalf("expected a stack fault, got %v", err)
+	}
+	if c.regs[2].GetInt() != 1 {
+		t.Errorf("subroutine did not return")
 	}
+	if c.stack.Size() != 1 {
+		t.Errorf("unexpected stack depth %d", c.stack.Size())
+	}
+}
+
+// Test that recursive subroutines may use local variables.
+func TestRecursion(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+        store #1, 5
+        push #1
+        call factorial
+        pop #0
+        exit
 
-	val, err := s.Pop()
+        # factorial returns the factorial of its argument, replacing
+        # the argument upon the stack with the result.
+:factorial
+        enter 1
+        load #1, -1
+        cmp #1, 1
+        jmpz done
 
+        # local 0 holds n - 1
+        store #2, 1
+        sub #1, #1, #2
+        save #1, 0
+
+        # factorial(n - 1)
+        push #1
+        call factorial
+        pop #1
+
+        # n * factorial(n - 1)
+        load #2, -1
+        mul #1, #1, #2
+        save #1, -1
+:done
+        leave
+        ret
+`))
+
+	_, err := c.Run()
 	if err != nil {
-		t.Errorf("Received an unexpected error popping from the stack")
+		t.Fatalf("unexpected error: %s", err.Error())
 	}
-	if !s.Empty() {
-		t.Errorf("Stack should be empty now.")
+	if c.regs[0].GetInt() != 120 {
+		t.Errorf("unexpected result %d", c.regs[0].GetInt())
 	}
-	if s.Size() != 0 {
-		t.Errorf("stack has a size-mismatch")
+	if c.stack.Size() != 0 || c.CallDepth() != 0 {
+		t.Errorf("stacks not empty: %d %d", c.stack.Size(), c.CallDepth())
 	}
+}
+
+// Test that the stack-pointer may be read and written.
+func TestStackPointer(t *testing.T) {
+	c := NewCPU()
+	c.LoadBytes(compile(t, `
+        push #1
+        push #1
+        push #1
+        getsp #2
+        store #3, 1
+        setsp #3
+        getsp #4
+        store #3, 4
+        setsp #3
+        getsp #5
+        exit
+`))
 
-	if val != 42 {
-		t.Errorf("Stack push/pop mismatch")
+	_, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	for reg, expected := range map[int]int{2: 3, 4: 1, 5: 4} {
+		if c.regs[reg].GetInt() != expected {
+			t.Errorf("register #%d holds %d, expected %d", reg, c.regs[reg].GetInt(), expected)
+		}
 	}
 }
 
-// Popping from an empty stack should fail
-func TestEmptyStack(t *testing.T) {
-	s := NewStack()
+// Test that faults include a stack-trace.
+func TestStackTrace(t *testing.T) {
+	e := compilerFor(`
+        call outer
+        exit
+:outer
+        nop
+        call inner
+        ret
+:inner
+        load #1, 3
+        ret
+`)
 
-	_, err := s.Pop()
+	c := NewCPU()
+	c.LoadBytes(e.Output())
+	c.SetSymbols(e.Labels())
+
+	_, err := c.Run()
+	f, ok := err.(*Fault)
+	if !ok || f.Kind != StackFault {
+		t.Fatalf("expected a stack fault, got %v", err)
+	}
 
-	if err == nil {
-		t.Errorf("should receive an error popping an empty stack!")
+	expected := []string{"inner, called from outer+0x1", "outer, called from 0000"}
+	if !reflect.DeepEqual(f.Trace, expected) {
+		t.Errorf("unexpected trace %q", f.Trace)
 	}
 }
@@ -0,0 +1,83 @@
+// This file contains the operations which manipulate strings.
+//
+// Strings are sequences of bytes, and positions within them count bytes
+// from zero.  Every instruction which expects a string faults with a
+// TypeFault if the register holds an integer instead, and vice versa.
+//
+// The operations are exported so that programs generated by `go.vm togo`
+// behave identically.
+
+package cpu
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Substr returns up to length bytes of the given string, beginning at
+// start.  The range is clipped to the string, so the result is empty if
+// start is beyond the end of it.
+func Substr(s string, start int, length int) string {
+	from, to := clip(len(s), start, length)
+	return s[from:to]
+}
+
+// clip returns the offsets of the range of up to length bytes, beginning
+// at start, which lie within a sequence of n bytes.
+func clip(n int, start int, length int) (int, int) {
+	if start < 0 {
+		length += start
+		start = 0
+	}
+	if start > n || length <= 0 {
+		return 0, 0
+	}
+	if length > n-start {
+		length = n - start
+	}
+	return start, start + length
+}
+
+// CharAt returns the byte found at the given position of a string,
+// faulting if the position is outside the string.
+func CharAt(s string, i int) (int, error) {
+	if i < 0 || i >= len(s) {
+		return 0, fault(IndexFault, "Index %d is outside the string of %d bytes", i, len(s))
+	}
+	return int(s[i]), nil
+}
+
+// Chr returns a string holding the single byte which is the bottom eight
+// bits of the given integer.
+func Chr(v int) string {
+	return string([]byte{byte(v)})
+}
+
+// ParseInt converts a string to an integer, in the given base.
+//
+// The base may be from 2 to 36, or zero to select it from the prefix of
+// the string as the compiler does: "0x" for hex, "0b" for binary, and
+// "0o" or "0" for octal.
+func ParseInt(s string, base int) (int, error) {
+	if base != 0 && (base < 2 || base > 36) {
+		return 0, fault(ConversionFault, "Invalid base %d", base)
+	}
+	i, err := strconv.ParseInt(s, base, 64)
+	if err != nil {
+		return 0, fault(ConversionFault, "Failed to convert '%s' to int: %s", s, err.Error())
+	}
+	return int(i), nil
+}
+
+// Split divides a string into the pieces which are separated by sep, or
+// into single bytes if sep is empty.
+func Split(s string, sep string) []string {
+	if sep == "" {
+		out := make([]string, len(s))
+		for i := 0; i < len(s); i++ {
+			out[i] = s[i : i+1]
+		}
+		return out
+	}
+	return strings.Split(s, sep)
+}
@@ -0,0 +1,116 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// Test that substrings are clipped to the string.
+func TestSubstr(t *testing.T) {
+
+	tests := []struct {
+		start, length int
+		result        string
+	}{
+		{0, 5, "Hello"},
+		{7, 100, "World"},
+		{-2, 4, "He"},
+		{12, 1, ""},
+		{100, 1, ""},
+		{3, 0, ""},
+		{3, -1, ""},
+	}
+
+	for _, test := range tests {
+		result := Substr("Hello, World", test.start, test.length)
+		if result != test.result {
+			t.Errorf("Substr(%d, %d) gave %q, expected %q", test.start, test.length, result, test.result)
+		}
+	}
+}
+
+// Test that strings are split at each separator, or into single bytes.
+func TestSplitString(t *testing.T) {
+
+	tests := []struct {
+		s, sep string
+		result []string
+	}{
+		{"a,b,,c", ",", []string{"a", "b", "", "c"}},
+		{"abc", "", []string{"a", "b", "c"}},
+		{"a\u00e9", "", []string{"a", "\xc3", "\xa9"}},
+		{"", "", []string{}},
+	}
+
+	for _, test := range tests {
+		result := Split(test.s, test.sep)
+		if len(result) != len(test.result) {
+			t.Errorf("Split(%q, %q) gave %q, expected %q", test.s, test.sep, result, test.result)
+			continue
+		}
+		for i := range result {
+			if result[i] != test.result[i] {
+				t.Errorf("Split(%q, %q) gave %q, expected %q", test.s, test.sep, result, test.result)
+				break
+			}
+		}
+	}
+}
+
+// Test the string instructions, via the registers they leave behind.
+func TestStringOps(t *testing.T) {
+
+	src := `
+        store #0, "  Steve Kemp "
+        trim #0
+        strlen #1, #0
+        store #2, "Kemp"
+        indexof #3, #0, #2
+        store #4, 4
+        charat #5, #0, #4
+        chr #6, #5
+        upper #6
+        store #7, "1,2,,3"
+        store #8, ","
+        split #9, #7, #8
+        pop #10
+        pop #11
+        store #12, "-7f"
+        string2int #12, 16
+        store #13, "0b101"
+        string2int #13, 0
+        store #14, "Kemp"
+        lower #14
+        indexof #15, #0, #14
+`
+	c := NewCPU()
+	c.LoadBytes(compile(t, src))
+	_, err := c.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	// The failed search leaves the Z-flag clear.
+	if c.flags.Zero() {
+		t.Errorf("expected the Z-flag to be clear")
+	}
+
+	ints := map[int]int{1: 10, 3: 6, 5: 'e', 9: 4, 12: 0xFF81, 13: 5, 15: 0xFFFF}
+	for reg, expected := range ints {
+		val, err := c.getInt(reg)
+		if err != nil || val != expected {
+			t.Errorf("register #%d holds %d (%v), expected %d", reg, val, err, expected)
+		}
+	}
+
+	strs := map[int]string{0: "Steve Kemp", 6: "E", 10: "1", 11: "2", 14: "kemp"}
+	for reg, expected := range strs {
+		val, err := c.getString(reg)
+		if err != nil || val != expected {
+			t.Errorf("register #%d holds %q (%v), expected %q", reg, val, err, expected)
+		}
+	}
+
+	if c.stack.Size() != 2 {
+		t.Errorf("expected two pieces left on the stack, found %d", c.stack.Size())
+	}
+}
@@ -0,0 +1,199 @@
+// This file contains the policy which controls the commands that may be
+// executed via the `system` instruction.
+
+package cpu
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SystemPolicy controls which commands the `system` instruction may run,
+// and how they're run.
+//
+// The zero value denies all commands, so a program cannot run anything
+// unless the embedder explicitly allows it.
+type SystemPolicy struct {
+	// Enabled must be set for any command to be run.
+	Enabled bool
+
+	// Allow lists the commands which may be run.  The first word of
+	// the command must match an entry exactly, so "/bin/ls" and "ls"
+	// are distinct.  If the list is empty any command may be run.
+	Allow []string
+
+	// Dir is the directory commands are run in.  If empty they are run
+	// in our current directory.
+	Dir string
+
+	// Env is the environment commands are run with, as NAME=value pairs.
+	// Commands never inherit our own environment.
+	Env []string
+
+	// Timeout is how long a command may run before it is killed, along
+	// with any processes it started, zero for no limit.
+	Timeout time.Duration
+
+	// MaxOutput is the number of bytes a command may write to STDOUT
+	// and STDERR combined before it is killed, zero for no limit.
+	MaxOutput int
+}
+
+// errOutputLimit is returned when a command writes too much output.
+var errOutputLimit = errors.New("output limit exceeded")
+
+// outputLimit tracks the output written by a command, across both
+// STDOUT and STDERR.
+type outputLimit struct {
+	sync.Mutex
+
+	// The number of bytes permitted, zero for no limit.
+	max int
+
+	// The number of bytes written so far.
+	used int
+
+	// Set if the limit was exceeded.
+	exceeded bool
+
+	// Kills the command.
+	cancel context.CancelFunc
+}
+
+// limitedBuffer collects the output of a command, killing the command
+// if it writes too much.
+//
+// The buffer isn't embedded, as its ReadFrom method would bypass Write.
+type limitedBuffer struct {
+	buf   bytes.Buffer
+	limit *outputLimit
+}
+
+// Write appends the given data to the buffer, unless doing so would
+// exceed the limit.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.limit.Lock()
+	defer b.limit.Unlock()
+
+	if b.limit.max > 0 && b.limit.used+len(p) > b.limit.max {
+		b.limit.exceeded = true
+		b.limit.cancel()
+		return 0, errOutputLimit
+	}
+	b.limit.used += len(p)
+	return b.buf.Write(p)
+}
+
+// SetSystemPolicy sets the policy which controls the commands that the
+// `system` instruction may run.  By default no commands may be run.
+func (c *CPU) SetSystemPolicy(p SystemPolicy) {
+	c.system = p
+}
+
+// commandResult holds the result of running a command.
+type commandResult struct {
+	// The output written to STDOUT and STDERR.
+	stdout []byte
+	stderr []byte
+
+	// The exit-status of the command.
+	status int
+}
+
+// runCommand runs the given command, subject to our policy, and returns
+// the output it wrote to STDOUT and STDERR along with its exit-status.
+// The command reads its input from stdin.
+//
+// A command which exits with a non-zero status is not an error, but one
+// which is denied by our policy, or cannot be run, is a fault.
+func (c *CPU) runCommand(command string, stdin string) (*commandResult, error) {
+
+	args := splitCommand(command)
+	if len(args) == 0 {
+		return &commandResult{}, nil
+	}
+
+	if !c.system.Enabled {
+		return nil, fault(SystemFault, "Running commands is not permitted: %s", args[0])
+	}
+	if len(c.system.Allow) > 0 {
+		allowed := false
+		for _, name := range c.system.Allow {
+			if name == args[0] {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return nil, fault(SystemFault, "Command not permitted: %s", args[0])
+		}
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if c.system.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), c.system.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	limit := &outputLimit{max: c.system.MaxOutput, cancel: cancel}
+	stdout := &limitedBuffer{limit: limit}
+	stderr := &limitedBuffer{limit: limit}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = c.system.Dir
+	cmd.Env = append([]string{}, c.system.Env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setGroup(cmd)
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, fault(SystemFault, "Failed to run %s: %s", args[0], err.Error())
+	}
+
+	// Wait for the command to finish, killing it if it runs for too
+	// long or writes too much.
+	//
+	// The whole process-group is killed, as a background process which
+	// inherited the output pipes would otherwise keep us waiting.
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killGroup(cmd)
+		err = <-done
+	}
+
+	switch {
+	case limit.exceeded:
+		return nil, fault(SystemFault, "Command %s wrote more than %d bytes", args[0], c.system.MaxOutput)
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, fault(SystemFault, "Command %s timed out after %s", args[0], c.system.Timeout)
+	}
+
+	res := &commandResult{stdout: stdout.buf.Bytes(), stderr: stderr.buf.Bytes()}
+	if err != nil {
+		exit, ok := err.(*exec.ExitError)
+		if !ok {
+			return nil, fault(SystemFault, "Failed to run %s: %s", args[0], err.Error())
+		}
+
+		// A command killed by a signal has no status of its own.
+		res.status = exit.ExitCode()
+		if res.status < 0 {
+			res.status = 0xFF
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,23 @@
+// +build !windows
+
+// This file contains the process-group handling used on Unix systems, so
+// that a command which starts background processes can be killed along
+// with all of them.
+
+package cpu
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setGroup arranges for the given command to run in a process-group of
+// its own.
+func setGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killGroup kills the given command, and every process in its group.
+func killGroup(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,17 @@
+// This file contains the process-group handling used on Windows, where
+// only the command itself is killed.
+
+package cpu
+
+import (
+	"os/exec"
+)
+
+// setGroup does nothing, as we have no process-groups.
+func setGroup(cmd *exec.Cmd) {
+}
+
+// killGroup kills the given command.
+func killGroup(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
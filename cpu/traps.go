@@ -7,9 +7,7 @@
 package cpu
 
 import (
-	"bufio"
 	"fmt"
-	"os"
 	"strings"
 )
 
@@ -17,17 +15,15 @@ import (
 // TrapFunction is the signature for a function that is available
 // as a trap.
 //
-type TrapFunction func(c *CPU, num int)
-
-//
-// TRAPS is an array of our trap-functions.
+// If the trap fails it should return an error, which will be reported
+// as a fault.
 //
-var TRAPS [0xffff]TrapFunction
+type TrapFunction func(c *CPU, num int) error
 
 //
-// Helper for reading from stdin
+// TRAPS is an array of our trap-functions.
 //
-var reader *bufio.Reader
+var TRAPS [0x10000]TrapFunction
 
 //
 // Trap Functions now follow
@@ -35,9 +31,8 @@ var reader *bufio.Reader
 
 // TrapNOP is the default trap-function for any trap IDs that haven't
 // explicitly been setup.
-func TrapNOP(c *CPU, num int) {
-	fmt.Printf("Trap function not defined: 0x%04X\n", num)
-	os.Exit(1)
+func TrapNOP(c *CPU, num int) error {
+	return fault(TrapFault, "Trap function not defined: 0x%04X", num)
 }
 
 // StrLenTrap returns the length of a string.
@@ -47,21 +42,26 @@ func TrapNOP(c *CPU, num int) {
 // Output:
 //   Sets register 0 with the length
 //
-func StrLenTrap(c *CPU, num int) {
-	str := c.regs[0].GetString()
-	c.regs[0].SetInt(len(str))
+func StrLenTrap(c *CPU, num int) error {
+	str, err := c.getString(0)
+	if err != nil {
+		return err
+	}
+	return c.setInt(0, len(str))
 }
 
-// ReadStringTrap reads a string from the console
+// ReadStringTrap reads a string from the console, or the input of the
+// CPU if one was given via SetInput.  Unlike `read_line` the newline is
+// kept, and the end of the input isn't reported.
 //
 // Input: None
 //
 // Ouptut:
 //   Sets register 0 with the user-provided string
 //
-func ReadStringTrap(c *CPU, num int) {
-	text, _ := reader.ReadString('\n')
-	c.regs[0].SetString(text)
+func ReadStringTrap(c *CPU, num int) error {
+	text, _ := c.reader().ReadString('\n')
+	return c.setString(0, text)
 }
 
 // RemoveNewLineTrap removes any trailing newline from the string in #0
@@ -71,23 +71,99 @@ func ReadStringTrap(c *CPU, num int) {
 // Output:
 //   Sets register #0 with the updated string
 //
-func RemoveNewLineTrap(c *CPU, num int) {
-	str := c.regs[0].GetString()
-	c.regs[0].SetString(strings.TrimSpace(str))
+func RemoveNewLineTrap(c *CPU, num int) error {
+	str, err := c.getString(0)
+	if err != nil {
+		return err
+	}
+	return c.setString(0, strings.TrimSpace(str))
+}
+
+// ArgCountTrap returns the number of command-line arguments.
+//
+// Input: None
+//
+// Output:
+//   Sets register 0 with the number of arguments, including the name
+//   of the program itself.
+//
+func ArgCountTrap(c *CPU, num int) error {
+	return c.setInt(0, len(c.args))
+}
+
+// ArgTrap returns a single command-line argument.
+//
+// Input:
+//   The index of the argument to fetch in register 0.
+//
+// Output:
+//   Sets register 0 with the argument, as a string.
+//
+func ArgTrap(c *CPU, num int) error {
+	i, err := c.getInt(0)
+	if err != nil {
+		return err
+	}
+	if i < 0 || i >= len(c.args) {
+		return fmt.Errorf("Argument %d out of range", i)
+	}
+	return c.setString(0, c.args[i])
+}
+
+// GetEnvTrap returns the value of an environment variable.
+//
+// Input:
+//   The name of the variable in register 0.
+//
+// Output:
+//   Sets register 0 with the value of the variable, as a string.  If the
+//   variable is not set, or not available to the program, the string
+//   will be empty.
+//
+func GetEnvTrap(c *CPU, num int) error {
+	name, err := c.getString(0)
+	if err != nil {
+		return err
+	}
+	return c.setString(0, c.env[name])
+}
+
+// SprintfTrap formats a template with the contents of registers.
+//
+// Input:
+//   The template in register 0, see sprintf in format.go.  The values
+//   for its placeholders in registers 1, 2, 3, etc.
+//
+// Output:
+//   Sets register 0 with the formatted string.
+//
+func SprintfTrap(c *CPU, num int) error {
+	template, err := c.getString(0)
+	if err != nil {
+		return err
+	}
+	str, err := c.sprintf(template)
+	if err != nil {
+		return err
+	}
+	return c.setString(0, str)
 }
 
 // Now implement the traps
 //
 func init() {
-	reader = bufio.NewReader(os.Stdin)
 	TRAPS[0] = StrLenTrap
 	TRAPS[1] = ReadStringTrap
 	TRAPS[2] = RemoveNewLineTrap
+	TRAPS[3] = ArgCountTrap
+	TRAPS[4] = ArgTrap
+	TRAPS[5] = GetEnvTrap
+	TRAPS[6] = SprintfTrap
 
 	// Fill in the rest of the traps with
 	// a function that will just report that
 	// the given ID is not implemented
-	for i := 0; i < 0xFFFF; i++ {
+	for i := 0; i < len(TRAPS); i++ {
 		if TRAPS[i] == nil {
 			TRAPS[i] = TrapNOP
 		}
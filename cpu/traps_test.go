@@ -0,0 +1,42 @@
+package cpu
+
+import (
+	"testing"
+)
+
+// Test that the command-line arguments may be fetched, and that fetching
+// one which doesn't exist is a fault.
+func TestArgTrap(t *testing.T) {
+
+	tests := []struct {
+		src    string
+		result string
+		fault  bool
+	}{
+		{"store #0, 0\nint 4\n", "prog", false},
+		{"store #0, 1\nint 4\n", "first", false},
+		{"store #0, 2\nint 4\n", "", true},
+		{"store #0, -1\nint 4\n", "", true},
+	}
+
+	for _, test := range tests {
+		c := NewCPU()
+		c.SetArgs([]string{"prog", "first"})
+		c.LoadBytes(compile(t, "bits 32\n"+test.src))
+
+		_, err := c.Run()
+		if test.fault {
+			if f, ok := err.(*Fault); !ok || f.Kind != TrapFault {
+				t.Errorf("expected a trap fault running %q, got %v", test.src, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error running %q: %s", test.src, err.Error())
+			continue
+		}
+		if c.regs[0].GetString() != test.result {
+			t.Errorf("running %q gave %q, expected %q", test.src, c.regs[0].GetString(), test.result)
+		}
+	}
+}
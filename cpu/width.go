@@ -0,0 +1,54 @@
+// This file contains the handling of the width of integers.
+//
+// By default integers are 16-bit and unsigned, but a program may select
+// 32 or 64-bit signed integers via its header.  Every operation which
+// stores an integer in a register wraps it to the width of the CPU, so
+// that arithmetic behaves the same way whichever instruction is used.
+
+package cpu
+
+import "fmt"
+
+// Width is the number of bits in the integers held by a CPU.
+type Width int
+
+const (
+	// Width16 is for unsigned integers in the range 0x0000-0xFFFF.
+	Width16 Width = 16
+
+	// Width32 is for signed 32-bit integers.
+	Width32 Width = 32
+
+	// Width64 is for signed 64-bit integers.
+	Width64 Width = 64
+)
+
+// Wrap returns the given value, wrapped around to fit within the width.
+func (w Width) Wrap(v int) int {
+	switch w {
+	case Width32:
+		return int(int32(v))
+	case Width64:
+		return int(int64(v))
+	default:
+		return v & 0xFFFF
+	}
+}
+
+// SetWidth sets the width of the integers the CPU holds.
+//
+// Loading a program resets the width to the one recorded in its header,
+// so this must be called after the program is loaded.
+func (c *CPU) SetWidth(w Width) error {
+	switch w {
+	case Width16, Width32, Width64:
+		c.width = w
+		return nil
+	}
+	return fmt.Errorf("unsupported integer width %d", w)
+}
+
+// Width returns the width of the integers the CPU holds.
+func (c *CPU) Width() Width {
+	return c.width
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/skx/go.vm/cpu"
+)
+
+//
+// parseArgs returns the non-flag arguments of the given flag-set, allowing
+// flags to appear after them - as in `go.vm togo file.raw -o file.go`.
+//
+// Anything following a `--` argument is returned separately, untouched.
+//
+func parseArgs(f *flag.FlagSet) ([]string, []string) {
+	var out []string
+
+	args := f.Args()
+	for len(args) > 0 {
+		if args[0] == "--" {
+			return out, args[1:]
+		}
+		if strings.HasPrefix(args[0], "-") && args[0] != "-" {
+
+			// Don't let the flag-parser consume the `--`.
+			var rest []string
+			for i, arg := range args {
+				if arg == "--" {
+					args, rest = args[:i], args[i:]
+					break
+				}
+			}
+
+			f.Parse(args)
+			args = append(f.Args(), rest...)
+			continue
+		}
+		out = append(out, args[0])
+		args = args[1:]
+	}
+	return out, nil
+}
+
+//
+// envFlag returns the named environment variables, ignoring any which
+// are not set.
+//
+func envFlag(names string) map[string]string {
+	env := make(map[string]string)
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if val, ok := os.LookupEnv(name); ok && name != "" {
+			env[name] = val
+		}
+	}
+	return env
+}
+
+//
+// systemFlags holds the flags which control the commands a program may
+// run via the `system` instruction.
+//
+type systemFlags struct {
+	// The commands which may be run, "*" for any.
+	allow string
+
+	// The directory commands are run in.
+	dir string
+
+	// How long commands may run for.
+	timeout time.Duration
+
+	// How much output commands may write.
+	output int
+}
+
+//
+// register adds our flags to the given flag-set.
+//
+func (s *systemFlags) register(f *flag.FlagSet) {
+	f.StringVar(&s.allow, "allow-system", "", "A comma-separated list of the commands the program may run, or '*' for any.  By default none may be run.")
+	f.StringVar(&s.dir, "system-dir", "", "The directory in which commands are run.")
+	f.DurationVar(&s.timeout, "system-timeout", 10*time.Second, "How long commands may run before they are killed.")
+	f.IntVar(&s.output, "system-output", 1024*1024, "The number of bytes of output commands may write before they are killed.")
+}
+
+//
+// policy returns the policy described by our flags.  Commands are run
+// with the given environment variables.
+//
+// Commands are only enabled for "*", or a list naming at least one
+// command, as an empty list would otherwise allow anything.
+//
+func (s *systemFlags) policy(env map[string]string) cpu.SystemPolicy {
+	p := cpu.SystemPolicy{
+		Enabled:   strings.TrimSpace(s.allow) == "*",
+		Dir:       s.dir,
+		Timeout:   s.timeout,
+		MaxOutput: s.output,
+	}
+
+	if !p.Enabled {
+		for _, name := range strings.Split(s.allow, ",") {
+			if strings.TrimSpace(name) != "" {
+				p.Allow = append(p.Allow, strings.TrimSpace(name))
+			}
+		}
+		p.Enabled = len(p.Allow) > 0
+	}
+
+	var names []string
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.Env = append(p.Env, name+"="+env[name])
+	}
+	return p
+}
+
+//
+// limitFlags holds the flags which control the resources a program may
+// use.
+//
+type limitFlags struct {
+	cpu.Limits
+
+	// The size of RAM, in bytes.
+	memory int
+}
+
+//
+// register adds our flags to the given flag-set.
+//
+func (l *limitFlags) register(f *flag.FlagSet) {
+	f.IntVar(&l.MaxString, "max-string", cpu.DefaultLimits.MaxString, "The maximum length of a string, zero for no limit.")
+	f.IntVar(&l.MaxStrings, "max-strings", cpu.DefaultLimits.MaxStrings, "The maximum size of all strings held in registers and on the stack, zero for no limit.")
+	f.IntVar(&l.MaxStack, "max-stack", cpu.DefaultLimits.MaxStack, "The maximum depth of the stack, zero for no limit.")
+	f.IntVar(&l.memory, "memory", cpu.DefaultMemory, "The size of RAM, in bytes.")
+}
+
+//
+// apply sets the limits described by our flags upon the given CPU.
+//
+func (l *limitFlags) apply(c *cpu.CPU) error {
+	c.SetLimits(l.Limits)
+	return c.SetMemory(l.memory)
+}
+
+//
+// deviceFlags holds the flags which attach devices to the memory of the
+// program.
+//
+type deviceFlags struct {
+	// The address of the console, or -1 for none.
+	console int
+
+	// The address of the timer, or -1 for none.
+	timer int
+}
+
+//
+// register adds our flags to the given flag-set.
+//
+func (d *deviceFlags) register(f *flag.FlagSet) {
+	f.IntVar(&d.console, "console", -1, "The address at which to attach a console device, which reads and writes characters via peek and poke.  By default none is attached.")
+	f.IntVar(&d.timer, "timer", -1, "The address at which to attach a timer device, which raises interrupts periodically.  By default none is attached.")
+}
+
+//
+// apply attaches the devices described by our flags to the given CPU.
+//
+func (d *deviceFlags) apply(c *cpu.CPU) error {
+	if d.console >= 0 {
+		err := c.Attach(d.console, cpu.ConsoleSize, cpu.NewConsole(c.Input(), os.Stdout))
+		if err != nil {
+			return err
+		}
+	}
+	if d.timer >= 0 {
+		return c.Attach(d.timer, cpu.TimerSize, cpu.NewTimer())
+	}
+	return nil
+}
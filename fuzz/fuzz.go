@@ -8,7 +8,15 @@ import (
 
 func Fuzz(data []byte) int {
 	c := cpu.NewCPU()
-	c.LoadBytes(data)
-	c.Run()
-	return 0
+	if c.LoadBytes(data) != nil {
+		return -1
+	}
+
+	// Faults are expected, but if the program ran
+	// successfully we'll prefer this input.
+	_, err := c.Run()
+	if err != nil {
+		return 0
+	}
+	return 1
 }
@@ -65,6 +65,14 @@ func (l *Lexer) NextToken() token.Token {
 			return l.readDecimal()
 		}
 
+		// Negative numbers.
+		if l.ch == rune('-') && isDigit(l.peekChar()) {
+			l.readChar()
+			tok = l.readDecimal()
+			tok.Literal = "-" + tok.Literal
+			return tok
+		}
+
 		tok.Literal = l.readIdentifier()
 		tok.Type = token.LookupIdentifier(tok.Literal)
 		return tok
@@ -114,3 +114,35 @@ print_int #21
 		}
 	}
 }
+
+func TestNegativeNumber(t *testing.T) {
+	input := `load #1, -1
+save #2, -0x10
+-`
+
+	tests := []struct {
+		expectedType    token.Type
+		expectedLiteral string
+	}{
+		{token.LOAD, "load"},
+		{token.IDENT, "#1"},
+		{token.COMMA, ","},
+		{token.INT, "-1"},
+		{token.SAVE, "save"},
+		{token.IDENT, "#2"},
+		{token.COMMA, ","},
+		{token.INT, "-0x10"},
+		{token.IDENT, "-"},
+		{token.EOF, ""},
+	}
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong, expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - Literal wrong, expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
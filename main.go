@@ -6,19 +6,33 @@ import (
 	"os"
 
 	"github.com/google/subcommands"
+	"github.com/skx/go.vm/pack"
 )
 
 //
 // Setup our sub-commands and use them.
 //
 func main() {
+
+	//
+	// If our executable contains a program then we run it, rather
+	// than behaving as go.vm.
+	//
+	if exe, err := os.Executable(); err == nil {
+		if p, perr := pack.Read(exe); perr == nil && p != nil {
+			os.Exit(int(runPacked(p)))
+		}
+	}
+
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
 	subcommands.Register(&compileCmd{}, "")
 	subcommands.Register(&dumpCmd{}, "")
 	subcommands.Register(&executeCmd{}, "")
+	subcommands.Register(&packCmd{}, "")
 	subcommands.Register(&runCmd{}, "")
+	subcommands.Register(&togoCmd{}, "")
 	subcommands.Register(&versionCmd{}, "")
 
 	flag.Parse()
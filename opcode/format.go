@@ -0,0 +1,68 @@
+// This file describes the format operand of INT_PRINT_FMT, which selects
+// the base an integer is printed in and the width it is padded to.
+//
+// A format is written as in printf, without the leading `%`: an optional
+// `0` to pad with zeros rather than spaces, an optional width, and one of
+// the verbs `d` (decimal), `x` or `X` (hex), `b` (binary) or `o` (octal).
+//
+// It is encoded as a two-byte number, with the verb in the low byte and
+// the width in the high byte.  The top bit of the width is set if the
+// integer is padded with zeros.
+
+package opcode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxFormatWidth is the widest field an integer may be padded to.
+const MaxFormatWidth = 0x7F
+
+// formatZero is the bit of the width which selects padding with zeros.
+const formatZero = 0x80
+
+// ParseFormat returns the encoding of the given format, such as "d" or
+// "08b".
+func ParseFormat(spec string) (int, error) {
+	if spec == "" {
+		return 0, fmt.Errorf("empty format")
+	}
+
+	verb := spec[len(spec)-1]
+	if !strings.ContainsRune("dxXbo", rune(verb)) {
+		return 0, fmt.Errorf("unknown format verb '%c' in %q", verb, spec)
+	}
+
+	width, zero := 0, false
+	digits := spec[:len(spec)-1]
+	if strings.HasPrefix(digits, "0") {
+		zero = true
+		digits = digits[1:]
+	}
+	if digits != "" {
+		n, err := strconv.Atoi(digits)
+		if err != nil || n < 0 || n > MaxFormatWidth {
+			return 0, fmt.Errorf("invalid width in format %q", spec)
+		}
+		width = n
+	}
+
+	if zero {
+		width |= formatZero
+	}
+	return int(verb) | width<<8, nil
+}
+
+// DecodeFormat returns the verb of the given encoded format, the width it
+// pads to, and whether it pads with zeros.  False is returned if the
+// format is invalid.
+func DecodeFormat(format int) (byte, int, bool, bool) {
+	verb := byte(format & 0xFF)
+	width := (format >> 8) & 0xFF
+	if !strings.ContainsRune("dxXbo", rune(verb)) {
+		return 0, 0, false, false
+	}
+	return verb, width &^ formatZero, width&formatZero != 0, true
+}
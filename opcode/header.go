@@ -0,0 +1,216 @@
+// This file describes the header which may precede a compiled program,
+// recording the settings the program must be executed with.
+//
+// A header begins with the bytes 0xFF 'G' 'V' 'M', as 0xFF is never a
+// valid opcode no program without a header can begin that way.  A series
+// of sections follow, each of which is a one-byte type, a two-byte
+// (little-endian) length, and that many bytes of data.  The header ends
+// with a section of type SectionEnd, and the program follows it.
+//
+// Programs which use the default settings have no header, so that they
+// are unchanged from those produced before headers existed.
+
+package opcode
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Magic is the start of a program which has a header.
+var Magic = []byte{0xFF, 'G', 'V', 'M'}
+
+const (
+	// SectionEnd marks the end of the header.
+	SectionEnd = 0x00
+
+	// SectionWidth holds the number of bits in an integer, as a single
+	// byte.
+	SectionWidth = 0x01
+
+	// SectionRegions holds the regions of memory which are protected,
+	// each of which is a four-byte start address, a four-byte end
+	// address, and a byte of permissions.
+	SectionRegions = 0x02
+
+	// SectionRegisters holds the number of registers the program uses,
+	// as a single byte.
+	SectionRegisters = 0x03
+)
+
+// The permissions a region of memory may have, which may be combined.
+const (
+	// PermRead allows the region to be read.
+	PermRead = 0x01
+
+	// PermWrite allows the region to be written.
+	PermWrite = 0x02
+
+	// PermExec allows the region to be executed.
+	PermExec = 0x04
+
+	// PermAll allows anything, as memory outside a region does.
+	PermAll = PermRead | PermWrite | PermExec
+)
+
+// Region is a range of memory, along with the permissions for accessing
+// it.
+type Region struct {
+	// Start is the first address of the region.
+	Start int
+
+	// End is the address following the last of the region.
+	End int
+
+	// Perm is the permissions of the region.
+	Perm byte
+}
+
+// ParsePerm returns the permissions described by the given string, such
+// as "rx" or "rw".  An empty string, or "-", means none.
+func ParsePerm(s string) (byte, error) {
+	var perm byte
+	for _, c := range s {
+		switch c {
+		case 'r':
+			perm |= PermRead
+		case 'w':
+			perm |= PermWrite
+		case 'x':
+			perm |= PermExec
+		case '-':
+		default:
+			return 0, fmt.Errorf("unknown permission '%c' in %q", c, s)
+		}
+	}
+	return perm, nil
+}
+
+// String returns the permissions of the region, as ParsePerm accepts them.
+func (r Region) String() string {
+	out := []byte("---")
+	for i, c := range "rwx" {
+		if r.Perm&(1<<uint(i)) != 0 {
+			out[i] = byte(c)
+		}
+	}
+	return fmt.Sprintf("%04X-%04X %s", r.Start, r.End, out)
+}
+
+// Header holds the settings recorded in the header of a program.
+type Header struct {
+	// Width is the number of bits in an integer: 16, 32 or 64.  Zero
+	// means the default of 16.
+	Width int
+
+	// Regions is the regions of memory which are protected, later ones
+	// taking precedence where they overlap.  Memory outside them may be
+	// accessed in any way.
+	Regions []Region
+
+	// Registers is the number of registers the program uses.  Zero means
+	// the default of 16.
+	Registers int
+}
+
+// Bytes returns the encoded header, or nil if the settings are all the
+// defaults and no header is required.
+func (h Header) Bytes() []byte {
+	var sections []byte
+
+	if h.Width != 0 && h.Width != 16 {
+		sections = append(sections, SectionWidth, 1, 0, byte(h.Width))
+	}
+
+	if h.Registers != 0 && h.Registers != Registers {
+		sections = append(sections, SectionRegisters, 1, 0, byte(h.Registers))
+	}
+
+	if len(h.Regions) > 0 {
+		length := len(h.Regions) * 9
+		sections = append(sections, SectionRegions, byte(length), byte(length>>8))
+		for _, r := range h.Regions {
+			sections = append(sections, long(r.Start)...)
+			sections = append(sections, long(r.End)...)
+			sections = append(sections, r.Perm)
+		}
+	}
+
+	if sections == nil {
+		return nil
+	}
+
+	out := append([]byte{}, Magic...)
+	out = append(out, sections...)
+	return append(out, SectionEnd, 0, 0)
+}
+
+// ParseHeader splits the given program into its header and the bytecode
+// which follows it.  If the program has no header the default settings
+// are returned, along with the whole program.
+func ParseHeader(data []byte) (Header, []byte, error) {
+	h := Header{}
+
+	if !bytes.HasPrefix(data, Magic) {
+		return h, data, nil
+	}
+
+	rest := data[len(Magic):]
+	for {
+		if len(rest) < 3 {
+			return h, nil, fmt.Errorf("truncated program header")
+		}
+		kind := rest[0]
+		length := int(rest[1]) + int(rest[2])*256
+		rest = rest[3:]
+		if len(rest) < length {
+			return h, nil, fmt.Errorf("truncated program header")
+		}
+		body := rest[:length]
+		rest = rest[length:]
+
+		switch kind {
+		case SectionEnd:
+			return h, rest, nil
+		case SectionWidth:
+			if length != 1 {
+				return h, nil, fmt.Errorf("invalid width section in program header")
+			}
+			h.Width = int(body[0])
+			if h.Width != 16 && h.Width != 32 && h.Width != 64 {
+				return h, nil, fmt.Errorf("unsupported integer width %d in program header", h.Width)
+			}
+		case SectionRegisters:
+			if length != 1 {
+				return h, nil, fmt.Errorf("invalid registers section in program header")
+			}
+			h.Registers = int(body[0])
+			if h.Registers < 1 || h.Registers > MaxRegisters {
+				return h, nil, fmt.Errorf("unsupported register count %d in program header", h.Registers)
+			}
+		case SectionRegions:
+			if length%9 != 0 {
+				return h, nil, fmt.Errorf("invalid regions section in program header")
+			}
+			for i := 0; i < length; i += 9 {
+				r := Region{Start: unlong(body[i:]), End: unlong(body[i+4:]), Perm: body[i+8]}
+				if r.Start > r.End || r.Perm&^PermAll != 0 {
+					return h, nil, fmt.Errorf("invalid region %s in program header", r)
+				}
+				h.Regions = append(h.Regions, r)
+			}
+		default:
+			return h, nil, fmt.Errorf("unknown section 0x%02X in program header", kind)
+		}
+	}
+}
+
+// long returns the four-byte (little-endian) encoding of the given number.
+func long(n int) []byte {
+	return []byte{byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)}
+}
+
+// unlong decodes the four-byte number at the start of the given bytes.
+func unlong(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16 | int(b[3])<<24
+}
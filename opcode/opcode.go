@@ -17,6 +17,20 @@ var (
 	// INT_RANDOM generates a random number.
 	INT_RANDOM = 0x04
 
+	// EXIT_IMMEDIATE exits with the given status.
+	EXIT_IMMEDIATE = 0x05
+
+	// EXIT_REG exits with the status held in the given register.
+	EXIT_REG = 0x06
+
+	// INT_PRINT_FMT prints the integer contents of a register, in the
+	// given format - see format.go.
+	INT_PRINT_FMT = 0x07
+
+	// INT_STORE_WIDE stores an eight-byte integer in a register, it is
+	// used by programs which have 32 or 64-bit integers.
+	INT_STORE_WIDE = 0x08
+
 	// JUMP_TO is an unconditional jump.
 	JUMP_TO = 0x10
 
@@ -26,6 +40,27 @@ var (
 	// JUMP_NZ jumps if the Z-flag is NOT set.
 	JUMP_NZ = 0x12
 
+	// JUMP_L jumps if the last comparison found the first value was less
+	// than the second.
+	JUMP_L = 0x13
+
+	// JUMP_G jumps if the first value was greater than the second.
+	JUMP_G = 0x14
+
+	// JUMP_LE jumps if the first value was less than, or equal to, the
+	// second.
+	JUMP_LE = 0x15
+
+	// JUMP_GE jumps if the first value was greater than, or equal to, the
+	// second.
+	JUMP_GE = 0x16
+
+	// JUMP_C jumps if the carry-flag is set.
+	JUMP_C = 0x17
+
+	// JUMP_NC jumps if the carry-flag is not set.
+	JUMP_NC = 0x18
+
 	// XOR_OP performs an XOR operation against to registers.
 	XOR_OP = 0x20
 
@@ -53,6 +88,24 @@ var (
 	// OR_OP performs a logical OR operation against to registers.
 	OR_OP = 0x28
 
+	// MOD_OP stores the remainder of dividing one register by another.
+	MOD_OP = 0x29
+
+	// SHL_OP shifts a register left.
+	SHL_OP = 0x2A
+
+	// SHR_OP shifts a register right, filling with zeros.
+	SHR_OP = 0x2B
+
+	// SAR_OP shifts a register right, preserving its sign.
+	SAR_OP = 0x2C
+
+	// ROL_OP rotates a register left.
+	ROL_OP = 0x2D
+
+	// ROR_OP rotates a register right.
+	ROR_OP = 0x2E
+
 	// STRING_STORE stores a string in a register.
 	STRING_STORE = 0x30
 
@@ -68,6 +121,42 @@ var (
 	// STRING_TOINT converts the given string-register contents to an int.
 	STRING_TOINT = 0x34
 
+	// STRING_SYSTEM_CAPTURE executes the system binary stored in the given
+	// string-register, storing its output and exit-status in registers.
+	STRING_SYSTEM_CAPTURE = 0x35
+
+	// STRING_LENGTH stores the length of a string in a register.
+	STRING_LENGTH = 0x36
+
+	// STRING_SUBSTR stores part of a string in a register.
+	STRING_SUBSTR = 0x37
+
+	// STRING_INDEX stores the position of one string within another.
+	STRING_INDEX = 0x38
+
+	// STRING_CHARAT stores the byte at a position of a string.
+	STRING_CHARAT = 0x39
+
+	// STRING_CHR converts an integer to a string of one byte.
+	STRING_CHR = 0x3A
+
+	// STRING_UPPER converts the given string-register to upper-case.
+	STRING_UPPER = 0x3B
+
+	// STRING_LOWER converts the given string-register to lower-case.
+	STRING_LOWER = 0x3C
+
+	// STRING_TRIM removes leading and trailing whitespace from the given
+	// string-register.
+	STRING_TRIM = 0x3D
+
+	// STRING_SPLIT splits a string, pushing the pieces onto the stack.
+	STRING_SPLIT = 0x3E
+
+	// STRING_TOINT_BASE converts the given string-register contents to
+	// an int, in the given base.
+	STRING_TOINT_BASE = 0x3F
+
 	// CMP_REG compares two registers.
 	CMP_REG = 0x40
 
@@ -83,6 +172,16 @@ var (
 	// IS_INTEGER tests if a register contains an integer.
 	IS_INTEGER = 0x44
 
+	// CMP_IMMEDIATE_WIDE compares a register with an eight-byte integer,
+	// it is used by programs which have 32 or 64-bit integers.
+	CMP_IMMEDIATE_WIDE = 0x45
+
+	// IS_BYTES tests if a register contains a byte-array.
+	IS_BYTES = 0x46
+
+	// IS_MAP tests if a register contains a map.
+	IS_MAP = 0x47
+
 	// NOP_OP does nothing.
 	NOP_OP = 0x50
 
@@ -98,6 +197,25 @@ var (
 	// MEMCPY copies a region of RAM.
 	MEMCPY = 0x62
 
+	// PEEK_WORD reads a two-byte word from memory.
+	PEEK_WORD = 0x63
+
+	// POKE_WORD writes a two-byte word to memory.
+	POKE_WORD = 0x64
+
+	// STORE_MEM writes a string to memory, followed by a NUL byte.
+	STORE_MEM = 0x65
+
+	// LOAD_MEM reads a string from memory, either up to a NUL byte or
+	// of a given length.
+	LOAD_MEM = 0x66
+
+	// MEMSET fills a region of RAM with a byte.
+	MEMSET = 0x67
+
+	// MEMCMP compares two regions of RAM.
+	MEMCMP = 0x68
+
 	// STACK_PUSH pushes the given register-contents onto the stack.
 	STACK_PUSH = 0x70
 
@@ -110,10 +228,198 @@ var (
 	// STACK_CALL calls a subroutine.
 	STACK_CALL = 0x73
 
+	// STACK_ENTER begins a stack-frame, reserving space for locals.
+	STACK_ENTER = 0x74
+
+	// STACK_LEAVE discards the current stack-frame.
+	STACK_LEAVE = 0x75
+
+	// STACK_LOAD copies a stack entry, relative to the frame, to a register.
+	STACK_LOAD = 0x76
+
+	// STACK_SAVE copies a register to a stack entry, relative to the frame.
+	STACK_SAVE = 0x77
+
+	// STACK_GETSP stores the depth of the stack in a register.
+	STACK_GETSP = 0x78
+
+	// STACK_SETSP sets the depth of the stack from a register.
+	STACK_SETSP = 0x79
+
 	// TRAP_OP invokes a CPU trap.
 	TRAP_OP = 0x80
+
+	// INT_ENABLE enables interrupts.
+	INT_ENABLE = 0x81
+
+	// INT_DISABLE disables interrupts.
+	INT_DISABLE = 0x82
+
+	// INT_RET returns from an interrupt handler.
+	INT_RET = 0x83
+
+	// NOT_OP inverts the bits of a register.
+	NOT_OP = 0x90
+
+	// NEG_OP negates a register.
+	NEG_OP = 0x91
+
+	// The immediate forms of the math operations take an eight-byte
+	// number in place of their final register, their opcodes are those
+	// of the register forms plus IMMEDIATE.
+
+	// XOR_IMMEDIATE is the immediate form of XOR_OP.
+	XOR_IMMEDIATE = 0xA0
+
+	// ADD_IMMEDIATE is the immediate form of ADD_OP.
+	ADD_IMMEDIATE = 0xA1
+
+	// SUB_IMMEDIATE is the immediate form of SUB_OP.
+	SUB_IMMEDIATE = 0xA2
+
+	// MUL_IMMEDIATE is the immediate form of MUL_OP.
+	MUL_IMMEDIATE = 0xA3
+
+	// DIV_IMMEDIATE is the immediate form of DIV_OP.
+	DIV_IMMEDIATE = 0xA4
+
+	// AND_IMMEDIATE is the immediate form of AND_OP.
+	AND_IMMEDIATE = 0xA7
+
+	// OR_IMMEDIATE is the immediate form of OR_OP.
+	OR_IMMEDIATE = 0xA8
+
+	// MOD_IMMEDIATE is the immediate form of MOD_OP.
+	MOD_IMMEDIATE = 0xA9
+
+	// SHL_IMMEDIATE is the immediate form of SHL_OP.
+	SHL_IMMEDIATE = 0xAA
+
+	// SHR_IMMEDIATE is the immediate form of SHR_OP.
+	SHR_IMMEDIATE = 0xAB
+
+	// SAR_IMMEDIATE is the immediate form of SAR_OP.
+	SAR_IMMEDIATE = 0xAC
+
+	// ROL_IMMEDIATE is the immediate form of ROL_OP.
+	ROL_IMMEDIATE = 0xAD
+
+	// ROR_IMMEDIATE is the immediate form of ROR_OP.
+	ROR_IMMEDIATE = 0xAE
+
+	// READ_LINE reads a line of input into a register.
+	READ_LINE = 0xB0
+
+	// READ_BYTE reads a byte of input into a register.
+	READ_BYTE = 0xB1
+
+	// READ_CHAR reads a character of input into a register, as a string.
+	READ_CHAR = 0xB2
+
+	// READ_INT reads a line of input into a register, as an integer.
+	READ_INT = 0xB3
+
+	// BYTES_NEW stores a byte-array, of the given length, in a register.
+	BYTES_NEW = 0xC0
+
+	// BYTES_GET stores the byte at a position of a byte-array.
+	BYTES_GET = 0xC1
+
+	// BYTES_SET sets the byte at a position of a byte-array.
+	BYTES_SET = 0xC2
+
+	// BYTES_SLICE stores part of a byte-array in a register.
+	BYTES_SLICE = 0xC3
+
+	// BYTES_APPEND appends a byte, string, or byte-array to a byte-array.
+	BYTES_APPEND = 0xC4
+
+	// BYTES_LENGTH stores the length of a byte-array in a register.
+	BYTES_LENGTH = 0xC5
+
+	// BYTES_TOSTRING converts the given byte-array register to a string.
+	BYTES_TOSTRING = 0xC6
+
+	// STRING_TOBYTES converts the given string-register to a byte-array.
+	STRING_TOBYTES = 0xC7
+
+	// MAP_NEW stores an empty map in a register.
+	MAP_NEW = 0xD0
+
+	// MAP_GET stores the value of a key of a map in a register.
+	MAP_GET = 0xD1
+
+	// MAP_SET sets the value of a key of a map.
+	MAP_SET = 0xD2
+
+	// MAP_DELETE removes a key from a map.
+	MAP_DELETE = 0xD3
+
+	// MAP_HAS tests if a map contains a key.
+	MAP_HAS = 0xD4
+
+	// MAP_KEYS pushes the keys of a map onto the stack.
+	MAP_KEYS = 0xD5
+
+	// The short immediate forms of the math operations take a two-byte
+	// number in place of their final register, their opcodes are those
+	// of the register forms plus IMMEDIATE_SHORT.
+
+	// XOR_IMMEDIATE_SHORT is the short immediate form of XOR_OP.
+	XOR_IMMEDIATE_SHORT = 0xE0
+
+	// ADD_IMMEDIATE_SHORT is the short immediate form of ADD_OP.
+	ADD_IMMEDIATE_SHORT = 0xE1
+
+	// SUB_IMMEDIATE_SHORT is the short immediate form of SUB_OP.
+	SUB_IMMEDIATE_SHORT = 0xE2
+
+	// MUL_IMMEDIATE_SHORT is the short immediate form of MUL_OP.
+	MUL_IMMEDIATE_SHORT = 0xE3
+
+	// DIV_IMMEDIATE_SHORT is the short immediate form of DIV_OP.
+	DIV_IMMEDIATE_SHORT = 0xE4
+
+	// AND_IMMEDIATE_SHORT is the short immediate form of AND_OP.
+	AND_IMMEDIATE_SHORT = 0xE7
+
+	// OR_IMMEDIATE_SHORT is the short immediate form of OR_OP.
+	OR_IMMEDIATE_SHORT = 0xE8
+
+	// MOD_IMMEDIATE_SHORT is the short immediate form of MOD_OP.
+	MOD_IMMEDIATE_SHORT = 0xE9
+
+	// SHL_IMMEDIATE_SHORT is the short immediate form of SHL_OP.
+	SHL_IMMEDIATE_SHORT = 0xEA
+
+	// SHR_IMMEDIATE_SHORT is the short immediate form of SHR_OP.
+	SHR_IMMEDIATE_SHORT = 0xEB
+
+	// SAR_IMMEDIATE_SHORT is the short immediate form of SAR_OP.
+	SAR_IMMEDIATE_SHORT = 0xEC
+
+	// ROL_IMMEDIATE_SHORT is the short immediate form of ROL_OP.
+	ROL_IMMEDIATE_SHORT = 0xED
+
+	// ROR_IMMEDIATE_SHORT is the short immediate form of ROR_OP.
+	ROR_IMMEDIATE_SHORT = 0xEE
 )
 
+// IMMEDIATE is the difference between the opcode of a math operation and
+// that of its immediate form.
+const IMMEDIATE = 0x80
+
+// IMMEDIATE_SHORT is the difference between the opcode of a math
+// operation and that of its short immediate form.
+const IMMEDIATE_SHORT = 0xC0
+
+// MathOps lists the math operations which take three registers, and so
+// have an immediate form.
+var MathOps = []int{
+	XOR_OP, ADD_OP, SUB_OP, MUL_OP, DIV_OP, AND_OP, OR_OP,
+	MOD_OP, SHL_OP, SHR_OP, SAR_OP, ROL_OP, ROR_OP,
+}
+
 // Opcode is a holder for a single instruction.
 // Note that this doesn't take any account of the arguments which might
 // be necessary.
@@ -142,12 +448,32 @@ func (o *Opcode) String() string {
 		return "INT_TOSTRING"
 	case INT_RANDOM:
 		return "INT_RANDOM"
+	case EXIT_IMMEDIATE:
+		return "EXIT_IMMEDIATE"
+	case EXIT_REG:
+		return "EXIT_REG"
+	case INT_PRINT_FMT:
+		return "INT_PRINT_FMT"
+	case INT_STORE_WIDE:
+		return "INT_STORE_WIDE"
 	case JUMP_TO:
 		return "JUMP_TO"
 	case JUMP_Z:
 		return "JUMP_Z"
 	case JUMP_NZ:
 		return "JUMP_NZ"
+	case JUMP_L:
+		return "JUMP_L"
+	case JUMP_G:
+		return "JUMP_G"
+	case JUMP_LE:
+		return "JUMP_LE"
+	case JUMP_GE:
+		return "JUMP_GE"
+	case JUMP_C:
+		return "JUMP_C"
+	case JUMP_NC:
+		return "JUMP_NC"
 
 	case XOR_OP:
 		return "XOR_OP"
@@ -167,6 +493,74 @@ func (o *Opcode) String() string {
 		return "AND_OP"
 	case OR_OP:
 		return "OR_OP"
+	case MOD_OP:
+		return "MOD_OP"
+	case SHL_OP:
+		return "SHL_OP"
+	case SHR_OP:
+		return "SHR_OP"
+	case SAR_OP:
+		return "SAR_OP"
+	case ROL_OP:
+		return "ROL_OP"
+	case ROR_OP:
+		return "ROR_OP"
+	case NOT_OP:
+		return "NOT_OP"
+	case NEG_OP:
+		return "NEG_OP"
+	case XOR_IMMEDIATE:
+		return "XOR_IMMEDIATE"
+	case ADD_IMMEDIATE:
+		return "ADD_IMMEDIATE"
+	case SUB_IMMEDIATE:
+		return "SUB_IMMEDIATE"
+	case MUL_IMMEDIATE:
+		return "MUL_IMMEDIATE"
+	case DIV_IMMEDIATE:
+		return "DIV_IMMEDIATE"
+	case AND_IMMEDIATE:
+		return "AND_IMMEDIATE"
+	case OR_IMMEDIATE:
+		return "OR_IMMEDIATE"
+	case MOD_IMMEDIATE:
+		return "MOD_IMMEDIATE"
+	case SHL_IMMEDIATE:
+		return "SHL_IMMEDIATE"
+	case SHR_IMMEDIATE:
+		return "SHR_IMMEDIATE"
+	case SAR_IMMEDIATE:
+		return "SAR_IMMEDIATE"
+	case ROL_IMMEDIATE:
+		return "ROL_IMMEDIATE"
+	case ROR_IMMEDIATE:
+		return "ROR_IMMEDIATE"
+	case XOR_IMMEDIATE_SHORT:
+		return "XOR_IMMEDIATE_SHORT"
+	case ADD_IMMEDIATE_SHORT:
+		return "ADD_IMMEDIATE_SHORT"
+	case SUB_IMMEDIATE_SHORT:
+		return "SUB_IMMEDIATE_SHORT"
+	case MUL_IMMEDIATE_SHORT:
+		return "MUL_IMMEDIATE_SHORT"
+	case DIV_IMMEDIATE_SHORT:
+		return "DIV_IMMEDIATE_SHORT"
+	case AND_IMMEDIATE_SHORT:
+		return "AND_IMMEDIATE_SHORT"
+	case OR_IMMEDIATE_SHORT:
+		return "OR_IMMEDIATE_SHORT"
+	case MOD_IMMEDIATE_SHORT:
+		return "MOD_IMMEDIATE_SHORT"
+	case SHL_IMMEDIATE_SHORT:
+		return "SHL_IMMEDIATE_SHORT"
+	case SHR_IMMEDIATE_SHORT:
+		return "SHR_IMMEDIATE_SHORT"
+	case SAR_IMMEDIATE_SHORT:
+		return "SAR_IMMEDIATE_SHORT"
+	case ROL_IMMEDIATE_SHORT:
+		return "ROL_IMMEDIATE_SHORT"
+	case ROR_IMMEDIATE_SHORT:
+		return "ROR_IMMEDIATE_SHORT"
 	case STRING_STORE:
 		return "STRING_STORE"
 	case STRING_PRINT:
@@ -175,8 +569,30 @@ func (o *Opcode) String() string {
 		return "STRING_CONCAT"
 	case STRING_SYSTEM:
 		return "STRING_SYSTEM"
+	case STRING_SYSTEM_CAPTURE:
+		return "STRING_SYSTEM_CAPTURE"
 	case STRING_TOINT:
 		return "STRING_TOINT"
+	case STRING_LENGTH:
+		return "STRING_LENGTH"
+	case STRING_SUBSTR:
+		return "STRING_SUBSTR"
+	case STRING_INDEX:
+		return "STRING_INDEX"
+	case STRING_CHARAT:
+		return "STRING_CHARAT"
+	case STRING_CHR:
+		return "STRING_CHR"
+	case STRING_UPPER:
+		return "STRING_UPPER"
+	case STRING_LOWER:
+		return "STRING_LOWER"
+	case STRING_TRIM:
+		return "STRING_TRIM"
+	case STRING_SPLIT:
+		return "STRING_SPLIT"
+	case STRING_TOINT_BASE:
+		return "STRING_TOINT_BASE"
 	case CMP_REG:
 		return "CMP_REG"
 	case CMP_IMMEDIATE:
@@ -187,6 +603,12 @@ func (o *Opcode) String() string {
 		return "IS_STRING"
 	case IS_INTEGER:
 		return "IS_INTEGER"
+	case CMP_IMMEDIATE_WIDE:
+		return "CMP_IMMEDIATE_WIDE"
+	case IS_BYTES:
+		return "IS_BYTES"
+	case IS_MAP:
+		return "IS_MAP"
 	case NOP_OP:
 		return "NOP"
 	case REG_STORE:
@@ -197,6 +619,18 @@ func (o *Opcode) String() string {
 		return "POKE"
 	case MEMCPY:
 		return "MEMCPY"
+	case PEEK_WORD:
+		return "PEEK_WORD"
+	case POKE_WORD:
+		return "POKE_WORD"
+	case STORE_MEM:
+		return "STORE_MEM"
+	case LOAD_MEM:
+		return "LOAD_MEM"
+	case MEMSET:
+		return "MEMSET"
+	case MEMCMP:
+		return "MEMCMP"
 	case STACK_PUSH:
 		return "PUSH"
 	case STACK_POP:
@@ -205,8 +639,62 @@ func (o *Opcode) String() string {
 		return "RET"
 	case STACK_CALL:
 		return "CALL"
+	case STACK_ENTER:
+		return "ENTER"
+	case STACK_LEAVE:
+		return "LEAVE"
+	case STACK_LOAD:
+		return "LOAD"
+	case STACK_SAVE:
+		return "SAVE"
+	case STACK_GETSP:
+		return "GETSP"
+	case STACK_SETSP:
+		return "SETSP"
 	case TRAP_OP:
 		return "TRAP"
+	case INT_ENABLE:
+		return "EI"
+	case INT_DISABLE:
+		return "DI"
+	case INT_RET:
+		return "IRET"
+	case READ_LINE:
+		return "READ_LINE"
+	case READ_BYTE:
+		return "READ_BYTE"
+	case READ_CHAR:
+		return "READ_CHAR"
+	case READ_INT:
+		return "READ_INT"
+	case BYTES_NEW:
+		return "BYTES_NEW"
+	case BYTES_GET:
+		return "BYTES_GET"
+	case BYTES_SET:
+		return "BYTES_SET"
+	case BYTES_SLICE:
+		return "BYTES_SLICE"
+	case BYTES_APPEND:
+		return "BYTES_APPEND"
+	case BYTES_LENGTH:
+		return "BYTES_LENGTH"
+	case BYTES_TOSTRING:
+		return "BYTES_TOSTRING"
+	case STRING_TOBYTES:
+		return "STRING_TOBYTES"
+	case MAP_NEW:
+		return "MAP_NEW"
+	case MAP_GET:
+		return "MAP_GET"
+	case MAP_SET:
+		return "MAP_SET"
+	case MAP_DELETE:
+		return "MAP_DELETE"
+	case MAP_HAS:
+		return "MAP_HAS"
+	case MAP_KEYS:
+		return "MAP_KEYS"
 	}
 	return "UNKNOWN OPCODE .."
 }
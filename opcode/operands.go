@@ -0,0 +1,243 @@
+// This file describes the arguments which follow each opcode in the
+// bytecode stream, and contains a decoder which uses that description.
+
+package opcode
+
+// Operand describes a single argument which follows an opcode.
+type Operand int
+
+const (
+	// Reg is a single byte holding a register number.
+	Reg Operand = iota
+
+	// Addr is a two-byte (little-endian) address.
+	Addr
+
+	// Num is a two-byte (little-endian) integer.
+	Num
+
+	// Str is a string, prefixed by its two-byte length.
+	Str
+
+	// Int is an eight-byte (little-endian) signed integer.
+	Int
+)
+
+// NoRegister is used in place of an optional register operand which
+// was not given.
+const NoRegister = 0xFF
+
+// Registers is the number of registers a CPU has by default.
+const Registers = 16
+
+// MaxRegisters is the largest number of registers a CPU may have, as
+// register operands are a single byte and NoRegister is reserved.
+const MaxRegisters = NoRegister
+
+// MaxStatus is the largest status a program may exit with, as the
+// statuses above it describe faults.
+const MaxStatus = 100
+
+// layouts holds the operands of each opcode, indexed by opcode.
+var layouts [256][]Operand
+
+// known records which opcodes are valid.
+var known [256]bool
+
+// Instruction is a single decoded instruction.
+type Instruction struct {
+	// Op is the opcode.
+	Op byte
+
+	// Args holds the register, address and integer operands in the
+	// order in which they appear.
+	Args []int
+
+	// Str holds the string operand, if the instruction has one.
+	Str string
+
+	// Size is the length of the encoded instruction in bytes.
+	Size int
+}
+
+// Operands returns the operands which follow the given opcode, and
+// false if the opcode is not recognized.
+func Operands(op byte) ([]Operand, bool) {
+	return layouts[op], known[op]
+}
+
+// Decode decodes the instruction found at the given offset in mem.
+//
+// Reads which run past the end of mem wrap around to the start of it.
+// If the opcode is not recognized false is returned, along with an
+// Instruction of size one.
+func Decode(mem []byte, addr int) (Instruction, bool) {
+	at := func(offset int) int {
+		return int(mem[(addr+offset)%len(mem)])
+	}
+
+	in := Instruction{Op: byte(at(0)), Size: 1}
+	if !known[in.Op] {
+		return in, false
+	}
+
+	for _, kind := range layouts[in.Op] {
+		switch kind {
+		case Reg:
+			in.Args = append(in.Args, at(in.Size))
+			in.Size++
+		case Addr, Num:
+			in.Args = append(in.Args, at(in.Size)+at(in.Size+1)*256)
+			in.Size += 2
+		case Int:
+			var v uint64
+			for i := 7; i >= 0; i-- {
+				v = v<<8 | uint64(at(in.Size+i))
+			}
+			in.Args = append(in.Args, int(int64(v)))
+			in.Size += 8
+		case Str:
+			length := at(in.Size) + at(in.Size+1)*256
+			in.Size += 2
+
+			start := (addr + in.Size) % len(mem)
+			if start+length <= len(mem) {
+				in.Str = string(mem[start : start+length])
+			} else {
+				buf := make([]byte, length)
+				for i := range buf {
+					buf[i] = byte(at(in.Size + i))
+				}
+				in.Str = string(buf)
+			}
+			in.Size += length
+		}
+	}
+	return in, true
+}
+
+// define records the operands of the given opcode.
+func define(op int, operands ...Operand) {
+	layouts[op] = operands
+	known[op] = true
+}
+
+func init() {
+	define(EXIT)
+	define(INT_STORE, Reg, Num)
+	define(INT_PRINT, Reg)
+	define(INT_TOSTRING, Reg)
+	define(INT_RANDOM, Reg)
+	define(EXIT_IMMEDIATE, Num)
+	define(EXIT_REG, Reg)
+	define(INT_PRINT_FMT, Reg, Num)
+	define(INT_STORE_WIDE, Reg, Int)
+
+	define(JUMP_TO, Addr)
+	define(JUMP_Z, Addr)
+	define(JUMP_NZ, Addr)
+	define(JUMP_L, Addr)
+	define(JUMP_G, Addr)
+	define(JUMP_LE, Addr)
+	define(JUMP_GE, Addr)
+	define(JUMP_C, Addr)
+	define(JUMP_NC, Addr)
+
+	define(XOR_OP, Reg, Reg, Reg)
+	define(ADD_OP, Reg, Reg, Reg)
+	define(SUB_OP, Reg, Reg, Reg)
+	define(MUL_OP, Reg, Reg, Reg)
+	define(DIV_OP, Reg, Reg, Reg)
+	define(INC_OP, Reg)
+	define(DEC_OP, Reg)
+	define(AND_OP, Reg, Reg, Reg)
+	define(OR_OP, Reg, Reg, Reg)
+	define(MOD_OP, Reg, Reg, Reg)
+	define(SHL_OP, Reg, Reg, Reg)
+	define(SHR_OP, Reg, Reg, Reg)
+	define(SAR_OP, Reg, Reg, Reg)
+	define(ROL_OP, Reg, Reg, Reg)
+	define(ROR_OP, Reg, Reg, Reg)
+	define(NOT_OP, Reg)
+	define(NEG_OP, Reg)
+	for _, op := range MathOps {
+		define(op+IMMEDIATE, Reg, Reg, Int)
+		define(op+IMMEDIATE_SHORT, Reg, Reg, Num)
+	}
+
+	define(STRING_STORE, Reg, Str)
+	define(STRING_PRINT, Reg)
+	define(STRING_CONCAT, Reg, Reg, Reg)
+	define(STRING_SYSTEM, Reg)
+	define(STRING_TOINT, Reg)
+	define(STRING_SYSTEM_CAPTURE, Reg, Reg, Reg, Reg, Reg)
+	define(STRING_LENGTH, Reg, Reg)
+	define(STRING_SUBSTR, Reg, Reg, Reg, Reg)
+	define(STRING_INDEX, Reg, Reg, Reg)
+	define(STRING_CHARAT, Reg, Reg, Reg)
+	define(STRING_CHR, Reg, Reg)
+	define(STRING_UPPER, Reg)
+	define(STRING_LOWER, Reg)
+	define(STRING_TRIM, Reg)
+	define(STRING_SPLIT, Reg, Reg, Reg)
+	define(STRING_TOINT_BASE, Reg, Num)
+
+	define(CMP_REG, Reg, Reg)
+	define(CMP_IMMEDIATE, Reg, Num)
+	define(CMP_STRING, Reg, Str)
+	define(IS_STRING, Reg)
+	define(IS_INTEGER, Reg)
+	define(CMP_IMMEDIATE_WIDE, Reg, Int)
+	define(IS_BYTES, Reg)
+	define(IS_MAP, Reg)
+
+	define(NOP_OP)
+	define(REG_STORE, Reg, Reg)
+
+	define(PEEK, Reg, Reg)
+	define(POKE, Reg, Reg)
+	define(MEMCPY, Reg, Reg, Reg)
+	define(PEEK_WORD, Reg, Reg)
+	define(POKE_WORD, Reg, Reg)
+	define(STORE_MEM, Reg, Reg)
+	define(LOAD_MEM, Reg, Reg, Reg)
+	define(MEMSET, Reg, Reg, Reg)
+	define(MEMCMP, Reg, Reg, Reg)
+
+	define(STACK_PUSH, Reg)
+	define(STACK_POP, Reg)
+	define(STACK_RET)
+	define(STACK_CALL, Addr)
+	define(STACK_ENTER, Num)
+	define(STACK_LEAVE)
+	define(STACK_LOAD, Reg, Num)
+	define(STACK_SAVE, Reg, Num)
+	define(STACK_GETSP, Reg)
+	define(STACK_SETSP, Reg)
+
+	define(TRAP_OP, Num)
+	define(INT_ENABLE)
+	define(INT_DISABLE)
+	define(INT_RET)
+
+	define(READ_LINE, Reg)
+	define(READ_BYTE, Reg)
+	define(READ_CHAR, Reg)
+	define(READ_INT, Reg)
+
+	define(BYTES_NEW, Reg, Reg)
+	define(BYTES_GET, Reg, Reg, Reg)
+	define(BYTES_SET, Reg, Reg, Reg)
+	define(BYTES_SLICE, Reg, Reg, Reg, Reg)
+	define(BYTES_APPEND, Reg, Reg)
+	define(BYTES_LENGTH, Reg, Reg)
+	define(BYTES_TOSTRING, Reg)
+	define(STRING_TOBYTES, Reg)
+
+	define(MAP_NEW, Reg)
+	define(MAP_GET, Reg, Reg, Reg)
+	define(MAP_SET, Reg, Reg, Reg)
+	define(MAP_DELETE, Reg, Reg)
+	define(MAP_HAS, Reg, Reg)
+	define(MAP_KEYS, Reg, Reg)
+}
@@ -0,0 +1,161 @@
+// Package pack allows a compiled program to be embedded within a copy of
+// our executable, and found again when that executable is launched.
+//
+// The program, and the settings it should be executed with, are appended
+// to the end of the executable.  They're followed by a trailer holding
+// their lengths and a magic-marker, so they can be found by reading the
+// end of the file:
+//
+//    [executable][bytecode][settings][len(bytecode)][len(settings)][magic]
+//
+// The settings are encoded as JSON, and the lengths are eight-byte
+// little-endian integers.
+//
+package pack
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/skx/go.vm/cpu"
+)
+
+// magic marks the end of an executable which contains a payload.
+const magic = "go.vm\x00pk"
+
+// trailerSize is the size of the lengths, and the magic-marker.
+const trailerSize = 8 + 8 + len(magic)
+
+// Payload is a program embedded within an executable, along with the
+// settings it should be executed with.
+type Payload struct {
+	// Program is the bytecode to execute.
+	Program []byte `json:"-"`
+
+	// Traps lists the traps the program may invoke, nil for all.
+	Traps []int `json:"traps,omitempty"`
+
+	// Env lists the environment variables available to the program.
+	Env []string `json:"env,omitempty"`
+
+	// System is the policy for the commands the program may run, nil
+	// if it may not run any.  The environment of the commands is taken
+	// from Env when the program is launched.
+	System *cpu.SystemPolicy `json:"system,omitempty"`
+
+	// Symbols holds the labels of the program, for stack-traces.
+	Symbols map[string]int `json:"symbols,omitempty"`
+}
+
+// Write creates the executable path, as a copy of the executable exe with
+// the given payload appended.
+func Write(exe string, path string, p *Payload) error {
+
+	in, err := os.Open(exe)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// If the executable already contains a payload we don't copy it.
+	size, _, err := find(in)
+	if err != nil {
+		return err
+	}
+
+	settings, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+
+	_, err = in.Seek(0, io.SeekStart)
+	if err == nil {
+		_, err = io.CopyN(out, in, size)
+	}
+	if err == nil {
+		_, err = out.Write(p.Program)
+	}
+	if err == nil {
+		_, err = out.Write(settings)
+	}
+	if err == nil {
+		trailer := make([]byte, trailerSize)
+		binary.LittleEndian.PutUint64(trailer[0:], uint64(len(p.Program)))
+		binary.LittleEndian.PutUint64(trailer[8:], uint64(len(settings)))
+		copy(trailer[16:], magic)
+		_, err = out.Write(trailer)
+	}
+
+	cerr := out.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Read returns the payload embedded within the named executable, or nil
+// if it doesn't contain one.
+func Read(path string) (*Payload, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	_, p, err := find(f)
+	return p, err
+}
+
+// find returns the size of the given executable, excluding any payload,
+// along with the payload itself.
+func find(f *os.File) (int64, *Payload, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+	size := info.Size()
+
+	if size < int64(trailerSize) {
+		return size, nil, nil
+	}
+
+	trailer := make([]byte, trailerSize)
+	_, err = f.ReadAt(trailer, size-int64(trailerSize))
+	if err != nil {
+		return 0, nil, err
+	}
+	if string(trailer[16:]) != magic {
+		return size, nil, nil
+	}
+
+	progLen := int64(binary.LittleEndian.Uint64(trailer[0:]))
+	setLen := int64(binary.LittleEndian.Uint64(trailer[8:]))
+
+	start := size - int64(trailerSize) - setLen - progLen
+	if progLen < 0 || setLen < 0 || start < 0 {
+		return 0, nil, fmt.Errorf("corrupt payload in %s", f.Name())
+	}
+
+	data := make([]byte, progLen+setLen)
+	_, err = f.ReadAt(data, start)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	p := &Payload{}
+	err = json.Unmarshal(data[progLen:], p)
+	if err != nil {
+		return 0, nil, fmt.Errorf("corrupt payload in %s - %s", f.Name(), err.Error())
+	}
+	p.Program = data[:progLen]
+
+	return start, p, nil
+}
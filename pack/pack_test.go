@@ -0,0 +1,68 @@
+package pack
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Test that a payload can be written and read back.
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pack")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	exe := filepath.Join(dir, "exe")
+	err = ioutil.WriteFile(exe, []byte("not really an executable"), 0755)
+	if err != nil {
+		t.Fatalf("failed to write file: %s", err.Error())
+	}
+
+	// A plain executable has no payload.
+	p, err := Read(exe)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if p != nil {
+		t.Fatalf("found a payload where there was none")
+	}
+
+	in := &Payload{Program: []byte{0x01, 0x02, 0x03}, Traps: []int{1, 2}}
+
+	out := filepath.Join(dir, "out")
+	err = Write(exe, out, in)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	p, err = Read(out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if p == nil {
+		t.Fatalf("payload was not found")
+	}
+	if !bytes.Equal(p.Program, in.Program) {
+		t.Errorf("program mismatch: %v != %v", p.Program, in.Program)
+	}
+	if !reflect.DeepEqual(p.Traps, in.Traps) {
+		t.Errorf("traps mismatch: %v != %v", p.Traps, in.Traps)
+	}
+
+	// Packing an executable which already holds a payload replaces it.
+	again := filepath.Join(dir, "again")
+	err = Write(out, again, &Payload{Program: []byte{0x00}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	a, _ := ioutil.ReadFile(again)
+	if !bytes.HasPrefix(a, []byte("not really an executable\x00")) {
+		t.Errorf("existing payload was not removed")
+	}
+}
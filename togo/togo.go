@@ -0,0 +1,996 @@
+// Package togo translates a compiled program into the source of a
+// standalone Go program, which can then be built into a native binary.
+//
+// The bytecode is disassembled by following every path of control-flow
+// from the start of the program, and split into basic blocks.  Each
+// block becomes a single case in a `switch` statement, which is executed
+// in a loop until the program exits.  The generated code uses the
+// registers, stack and limits of a `cpu.CPU`, and raises the same faults,
+// so the behaviour of each instruction is unchanged.
+//
+// Programs which might modify their own code, because they write to an
+// address which is within it or which is computed as they run, cannot be
+// translated.  Neither can programs which use an instruction we
+// don't know how to translate.  In either case the generated program
+// embeds the bytecode and runs it via the interpreter instead.
+//
+package togo
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+
+	"github.com/skx/go.vm/cpu"
+	"github.com/skx/go.vm/opcode"
+)
+
+// memSize is the amount of RAM the interpreter provides.
+const memSize = cpu.DefaultMemory
+
+// Translator holds our state.
+type Translator struct {
+	// The program we're translating, including any header.
+	program []byte
+
+	// The bytecode which follows the header.
+	bytecode []byte
+
+	// The width of the integers the program uses.
+	width cpu.Width
+
+	// The number of registers the program uses.
+	registers int
+
+	// The regions of memory the program protects.
+	regions []opcode.Region
+
+	// The error found in the header of the program, if any.
+	err error
+
+	// RAM, as it will be when the program starts.
+	mem []byte
+
+	// The instructions which are reachable, by address.
+	code map[int]opcode.Instruction
+
+	// The addresses which begin a basic block.
+	leaders map[int]bool
+
+	// Is the translated program using the given feature?
+	uses map[string]bool
+
+	// The reason we fell back to the interpreter, if we did.
+	reason string
+
+	// The environment variables the program may see.
+	env []string
+
+	// The commands the program may run.
+	system cpu.SystemPolicy
+}
+
+// New is our constructor.
+func New(program []byte) *Translator {
+	t := &Translator{program: program, width: cpu.Width16, registers: opcode.Registers}
+
+	header, bytecode, err := opcode.ParseHeader(program)
+	t.bytecode, t.err = bytecode, err
+	if header.Width != 0 {
+		t.width = cpu.Width(header.Width)
+	}
+	if header.Registers != 0 {
+		t.registers = header.Registers
+	}
+	t.regions = header.Regions
+
+	t.mem = make([]byte, memSize)
+	copy(t.mem, t.bytecode)
+	t.code = make(map[int]opcode.Instruction)
+	t.leaders = make(map[int]bool)
+	t.uses = make(map[string]bool)
+	return t
+}
+
+// SetEnv sets the names of the environment variables which are available
+// to the generated program.  None are available by default.
+func (t *Translator) SetEnv(names []string) {
+	t.env = names
+}
+
+// SetSystemPolicy sets the policy which controls the commands that the
+// generated program may run, with the environment variables given to
+// SetEnv.  By default no commands may be run.
+func (t *Translator) SetSystemPolicy(p cpu.SystemPolicy) {
+	t.system = p
+}
+
+// Translated returns true if the program was translated, and false if the
+// generated program embeds the interpreter instead.
+//
+// If the interpreter is embedded the reason is returned too.
+func (t *Translator) Translated() (bool, string) {
+	return t.reason == "", t.reason
+}
+
+// Translate returns the source of the generated program.
+func (t *Translator) Translate() ([]byte, error) {
+	var body string
+
+	if t.err != nil {
+		return nil, t.err
+	}
+	if len(t.bytecode) > memSize {
+		return nil, fmt.Errorf("program too large for RAM")
+	}
+
+	t.reason = t.disassemble()
+	if t.reason == "" {
+		body = t.translate()
+	} else {
+		body = t.interpret()
+	}
+
+	var setup bytes.Buffer
+	t.setup(&setup)
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by go.vm togo; DO NOT EDIT.\n\n")
+	out.WriteString("package main\n\n")
+
+	out.WriteString("import (\n")
+	for _, pkg := range []string{"bytes", "fmt", "math/rand", "os", "strconv", "strings", "time"} {
+		if t.uses[pkg] {
+			fmt.Fprintf(&out, "%q\n", pkg)
+		}
+	}
+	out.WriteString("\n\"github.com/skx/go.vm/cpu\"\n")
+	out.WriteString(")\n\n")
+
+	if t.uses["program"] {
+		// The interpreter needs the header, translated code doesn't.
+		embed := t.bytecode
+		if t.reason != "" {
+			embed = t.program
+		}
+
+		out.WriteString("// program is the bytecode we were generated from.\n")
+		out.WriteString("var program = []byte{")
+		for i, b := range embed {
+			if i%16 == 0 {
+				out.WriteString("\n")
+			}
+			fmt.Fprintf(&out, "0x%02X, ", b)
+		}
+		out.WriteString("\n}\n\n")
+	}
+
+	if t.uses["fault"] {
+		out.WriteString(`// fault reports an error raised by the instruction at the given
+// address, and exits with the status the interpreter would use.
+func fault(ip int, err error) {
+	f, ok := err.(*cpu.Fault)
+	if !ok {
+		f = &cpu.Fault{Kind: cpu.TrapFault, Message: err.Error()}
+	}
+	f.IP = ip
+	fmt.Printf("%s\n", f.Error())
+	os.Exit(f.ExitCode())
+}
+
+`)
+	}
+
+	if t.uses["getInt"] {
+		out.WriteString(`// getInt returns the integer held in a register, faulting if it holds
+// something else.
+func getInt(c *cpu.CPU, ip int, reg int) int {
+	v, err := c.GetInt(reg)
+	if err != nil {
+		fault(ip, err)
+	}
+	return v
+}
+
+`)
+	}
+
+	if t.uses["getString"] {
+		out.WriteString(`// getString returns the string held in a register, faulting if it holds
+// something else.
+func getString(c *cpu.CPU, ip int, reg int) string {
+	s, err := c.GetString(reg)
+	if err != nil {
+		fault(ip, err)
+	}
+	return s
+}
+
+`)
+	}
+
+	if t.uses["setString"] {
+		out.WriteString(`// setString stores a string in a register, faulting if it exceeds our
+// limits.
+func setString(c *cpu.CPU, ip int, reg int, s string) {
+	if err := c.SetString(reg, s); err != nil {
+		fault(ip, err)
+	}
+}
+
+`)
+	}
+
+	out.Write(setup.Bytes())
+	out.WriteString(body)
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %s", err.Error())
+	}
+	return src, nil
+}
+
+// setup writes the function which passes our command-line arguments,
+// environment, and policy to the CPU when the generated program starts.
+func (t *Translator) setup(out *bytes.Buffer) {
+	out.WriteString(`// setup passes our command-line arguments, and the environment variables
+// the program may see, to the CPU.
+func setup(c *cpu.CPU) {
+	c.SetArgs(os.Args)
+
+`)
+	fmt.Fprintf(out, "names := %#v\n", t.env)
+	out.WriteString(`env := make(map[string]string)
+	for _, name := range names {
+		if val, ok := os.LookupEnv(name); ok {
+			env[name] = val
+		}
+	}
+	c.SetEnv(env)
+`)
+
+	if t.system.Enabled {
+		fmt.Fprintf(out, "\npolicy := cpu.SystemPolicy{Enabled: true, Allow: %#v, Dir: %q, Timeout: %d, MaxOutput: %d}\n",
+			t.system.Allow, t.system.Dir, t.system.Timeout, t.system.MaxOutput)
+		out.WriteString(`for _, name := range names {
+		if val, ok := env[name]; ok {
+			policy.Env = append(policy.Env, name+"="+val)
+		}
+	}
+	c.SetSystemPolicy(policy)
+`)
+	}
+	out.WriteString("}\n\n")
+	t.uses["os"] = true
+}
+
+// disassemble finds every instruction which is reachable from the start
+// of the program, and the addresses at which basic blocks begin.
+//
+// If the program cannot be translated the reason is returned.
+func (t *Translator) disassemble() string {
+
+	// Only the interpreter enforces the protection of memory.
+	if len(t.regions) > 0 {
+		return "protected memory cannot be translated"
+	}
+
+	// Registers which exist.
+	regs := t.registers
+
+	// Which addresses are covered by an instruction?
+	covered := make(map[int]int)
+
+	todo := []int{0}
+	t.leaders[0] = true
+
+	for len(todo) > 0 {
+		addr := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		for {
+			if _, seen := t.code[addr]; seen {
+				break
+			}
+
+			in, ok := opcode.Decode(t.mem, addr)
+			if !ok {
+				return fmt.Sprintf("unknown opcode %02X at %04X", in.Op, addr)
+			}
+
+			switch int(in.Op) {
+			case opcode.STRING_SYSTEM, opcode.STRING_SYSTEM_CAPTURE,
+				opcode.PEEK_WORD, opcode.STORE_MEM, opcode.LOAD_MEM,
+				opcode.MEMCMP, opcode.INT_ENABLE, opcode.INT_DISABLE, opcode.INT_RET,
+				opcode.STACK_ENTER, opcode.STACK_LEAVE, opcode.STACK_LOAD, opcode.STACK_SAVE,
+				opcode.STACK_GETSP, opcode.STACK_SETSP,
+				opcode.IS_BYTES, opcode.BYTES_NEW, opcode.BYTES_GET, opcode.BYTES_SET, opcode.BYTES_SLICE,
+				opcode.BYTES_APPEND, opcode.BYTES_LENGTH, opcode.BYTES_TOSTRING, opcode.STRING_TOBYTES,
+				opcode.IS_MAP, opcode.MAP_NEW, opcode.MAP_GET, opcode.MAP_SET, opcode.MAP_DELETE,
+				opcode.MAP_HAS, opcode.MAP_KEYS:
+				return fmt.Sprintf("%s at %04X cannot be translated", opcode.NewOpcode(in.Op).String(), addr)
+			}
+
+			// Ensure registers are valid.
+			layout, _ := opcode.Operands(in.Op)
+			for i, kind := range layout {
+				if kind == opcode.Reg && in.Args[i] >= regs {
+					return fmt.Sprintf("register %d out of range at %04X", in.Args[i], addr)
+				}
+			}
+
+			// Instructions must not overlap.
+			for i := 0; i < in.Size; i++ {
+				if other, found := covered[addr+i]; found && other != addr {
+					return fmt.Sprintf("overlapping instructions at %04X", addr+i)
+				}
+				covered[addr+i] = addr
+			}
+			if addr+in.Size > memSize {
+				return fmt.Sprintf("instruction at %04X wraps around RAM", addr)
+			}
+
+			t.code[addr] = in
+			next := addr + in.Size
+
+			// Branch targets begin new blocks, as does anything
+			// which follows a branch.
+			_, conditional := conditions[int(in.Op)]
+			switch {
+			case int(in.Op) == opcode.JUMP_TO:
+				t.leaders[in.Args[0]] = true
+				todo = append(todo, in.Args[0])
+			case conditional, int(in.Op) == opcode.STACK_CALL:
+				t.leaders[in.Args[0]] = true
+				t.leaders[next] = true
+				todo = append(todo, in.Args[0], next)
+			}
+
+			if t.terminates(in) {
+				break
+			}
+			addr = next
+		}
+	}
+
+	for addr := range t.leaders {
+		if addr >= memSize {
+			return fmt.Sprintf("branch to %04X is outside RAM", addr)
+		}
+	}
+	return t.checkWrites(covered)
+}
+
+// checkWrites tests that the instructions which write to RAM can't
+// modify the code of the program, which covers the given addresses.
+//
+// That is only certain if the address, and length, were stored in their
+// registers earlier in the same basic block, so anything else is assumed
+// to modify the code and the reason is returned.
+func (t *Translator) checkWrites(covered map[int]int) string {
+	var addrs []int
+	for addr := range t.leaders {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+
+	for _, addr := range addrs {
+		// The integers we know the registers hold.
+		known := make(map[int]int)
+
+		for {
+			in := t.code[addr]
+			name := opcode.NewOpcode(in.Op).String()
+
+			// Find the registers holding the address, and the
+			// length, which the instruction writes.
+			dst, length, n := -1, -1, 0
+			switch int(in.Op) {
+			case opcode.POKE:
+				dst, n = in.Args[1], 1
+			case opcode.POKE_WORD:
+				dst, n = in.Args[1], 2
+			case opcode.MEMSET, opcode.MEMCPY:
+				dst, length = in.Args[0], in.Args[2]
+			}
+
+			if dst >= 0 {
+				start, found := known[dst]
+				if !found {
+					return fmt.Sprintf("%s at %04X writes to a computed address", name, addr)
+				}
+				if length >= 0 {
+					n, found = known[length]
+					if !found {
+						return fmt.Sprintf("%s at %04X writes a computed length", name, addr)
+					}
+				}
+
+				// Writes which don't fit in RAM fault, rather
+				// than writing anything.
+				if start >= 0 && start+n <= memSize {
+					for i := start; i < start+n; i++ {
+						if _, code := covered[i]; code {
+							return fmt.Sprintf("%s at %04X writes to the program", name, addr)
+						}
+					}
+				}
+			}
+
+			layout, _ := opcode.Operands(in.Op)
+			switch int(in.Op) {
+			case opcode.INT_STORE, opcode.INT_STORE_WIDE:
+				known[in.Args[0]] = t.width.Wrap(in.Args[1])
+			case opcode.POKE, opcode.POKE_WORD, opcode.MEMSET, opcode.MEMCPY:
+				// These only read their registers.
+			case opcode.TRAP_OP:
+				// Traps may change any register.
+				known = make(map[int]int)
+			default:
+				for i, kind := range layout {
+					if kind == opcode.Reg {
+						delete(known, in.Args[i])
+					}
+				}
+			}
+
+			if t.terminates(in) {
+				break
+			}
+			addr += in.Size
+			if t.leaders[addr] {
+				break
+			}
+		}
+	}
+	return ""
+}
+
+// interpret returns the body of a program which runs the bytecode via
+// the interpreter.
+func (t *Translator) interpret() string {
+	t.uses["program"] = true
+	t.uses["fmt"] = true
+	t.uses["os"] = true
+
+	return fmt.Sprintf(`// This program could not be translated: %s.
+func main() {
+	c := cpu.NewCPU()
+	setup(c)
+	if err := c.LoadBytes(program); err != nil {
+		fmt.Printf("%%s\n", err.Error())
+		os.Exit(1)
+	}
+
+	status, err := c.Run()
+	if err != nil {
+		fmt.Printf("%%s\n", err.Error())
+		if f, ok := err.(*cpu.Fault); ok {
+			os.Exit(f.ExitCode())
+		}
+		os.Exit(1)
+	}
+	os.Exit(status)
+}
+`, t.reason)
+}
+
+// translate returns the body of the translated program.
+func (t *Translator) translate() string {
+	var addrs []int
+	for addr := range t.leaders {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+
+	var blocks strings.Builder
+	for _, addr := range addrs {
+		fmt.Fprintf(&blocks, "case 0x%04X:\n", addr)
+
+		for {
+			in := t.code[addr]
+			fmt.Fprintf(&blocks, "// %04X: %s\n", addr, t.describe(in))
+			blocks.WriteString(t.instruction(in, addr+in.Size))
+
+			if t.terminates(in) {
+				break
+			}
+
+			// Continue with the block which follows.
+			addr += in.Size
+			if t.leaders[addr] {
+				fmt.Fprintf(&blocks, "pc = 0x%04X\ncontinue\n", addr)
+				break
+			}
+		}
+	}
+
+	var out strings.Builder
+	out.WriteString("func main() {\n")
+	out.WriteString("c := cpu.NewCPU()\n")
+	out.WriteString("setup(c)\n")
+	if t.registers != opcode.Registers {
+		fmt.Fprintf(&out, "if err := c.SetRegisters(%d); err != nil {\nfmt.Printf(\"%%s\\n\", err.Error())\nos.Exit(1)\n}\n", t.registers)
+	}
+	if t.uses["memory"] {
+		t.uses["program"] = true
+		out.WriteString("if err := c.StoreMem(0, program); err != nil {\nfmt.Printf(\"%s\\n\", err.Error())\nos.Exit(1)\n}\n")
+	}
+	out.WriteString("r := c.Registers()\n")
+	if t.width != cpu.Width16 {
+		fmt.Fprintf(&out, "c.SetWidth(%d)\n", t.width)
+	}
+	if t.uses["width"] {
+		out.WriteString("w := c.Width()\n")
+	}
+	if t.uses["calls"] {
+		out.WriteString("var calls []int\n")
+	}
+	if t.uses["flags"] {
+		out.WriteString("var f cpu.Flags\n")
+		out.WriteString("var v int\n")
+		out.WriteString("_, _ = f, v\n")
+	}
+	out.WriteString("_ = r\n\n")
+
+	out.WriteString("pc := 0\n")
+	out.WriteString("for {\n")
+	out.WriteString("switch pc {\n")
+	out.WriteString(blocks.String())
+	out.WriteString("default:\n")
+	out.WriteString("fmt.Printf(\"Jump to untranslated address %04X\\n\", pc)\n")
+	out.WriteString("os.Exit(1)\n")
+	out.WriteString("}\n")
+	out.WriteString("}\n")
+	out.WriteString("}\n")
+
+	t.uses["fmt"] = true
+	t.uses["os"] = true
+	return out.String()
+}
+
+// conditions holds the Go expression which decides whether each of the
+// conditional jumps is taken, see cpu/flags.go.
+var conditions = map[int]string{
+	opcode.JUMP_Z:  "f.Zero()",
+	opcode.JUMP_NZ: "!f.Zero()",
+	opcode.JUMP_L:  "f.Less(w)",
+	opcode.JUMP_G:  "!f.Less(w) && !f.Zero()",
+	opcode.JUMP_LE: "f.Less(w) || f.Zero()",
+	opcode.JUMP_GE: "!f.Less(w)",
+	opcode.JUMP_C:  "f.Carry()",
+	opcode.JUMP_NC: "!f.Carry()",
+}
+
+// mathExprs holds the Go expression which implements each of the math
+// operations which take two operands, see cpu/flags.go.
+var mathExprs = map[int]string{
+	opcode.XOR_OP: "w.Logic(%s ^ %s)",
+	opcode.AND_OP: "w.Logic(%s & %s)",
+	opcode.OR_OP:  "w.Logic(%s | %s)",
+	opcode.ADD_OP: "w.Add(%s, %s)",
+	opcode.SUB_OP: "w.Sub(%s, %s)",
+	opcode.MUL_OP: "w.Mul(%s, %s)",
+	opcode.DIV_OP: "w.Div(%s, %s)",
+	opcode.MOD_OP: "w.Mod(%s, %s)",
+	opcode.SHL_OP: "w.Shl(%s, %s)",
+	opcode.SHR_OP: "w.Shr(%s, %s)",
+	opcode.SAR_OP: "w.Sar(%s, %s)",
+	opcode.ROL_OP: "w.Rol(%s, %s)",
+	opcode.ROR_OP: "w.Ror(%s, %s)",
+}
+
+// unaryExprs holds the Go expression which implements each of the math
+// operations which take a single register.
+var unaryExprs = map[int]string{
+	opcode.INC_OP: "w.Add(%s, 1)",
+	opcode.DEC_OP: "w.Sub(%s, 1)",
+	opcode.NOT_OP: "w.Not(%s)",
+	opcode.NEG_OP: "w.Neg(%s)",
+}
+
+// stringFuncs holds the function from the strings package which
+// implements each of the instructions which modify a string in-place.
+var stringFuncs = map[int]string{
+	opcode.STRING_UPPER: "ToUpper",
+	opcode.STRING_LOWER: "ToLower",
+	opcode.STRING_TRIM:  "TrimSpace",
+}
+
+// inputFuncs holds the method of the CPU which implements each of the
+// instructions which read input, see cpu/input.go.
+var inputFuncs = map[int]string{
+	opcode.READ_LINE: "InputLine",
+	opcode.READ_BYTE: "InputByte",
+	opcode.READ_CHAR: "InputChar",
+	opcode.READ_INT:  "InputInt",
+}
+
+// terminates returns true if the given instruction ends a basic block.
+func (t *Translator) terminates(in opcode.Instruction) bool {
+	switch int(in.Op) {
+	case opcode.EXIT, opcode.EXIT_IMMEDIATE, opcode.EXIT_REG,
+		opcode.STACK_RET, opcode.STACK_CALL, opcode.JUMP_TO:
+		return true
+	}
+	_, conditional := conditions[int(in.Op)]
+	return conditional
+}
+
+// describe returns a human-readable version of the given instruction.
+func (t *Translator) describe(in opcode.Instruction) string {
+	var args []string
+	layout, _ := opcode.Operands(in.Op)
+	for i, kind := range layout {
+		switch kind {
+		case opcode.Reg:
+			args = append(args, fmt.Sprintf("#%d", in.Args[i]))
+		case opcode.Addr, opcode.Num:
+			args = append(args, fmt.Sprintf("0x%04X", in.Args[i]))
+		case opcode.Int:
+			args = append(args, fmt.Sprintf("%d", in.Args[i]))
+		case opcode.Str:
+			args = append(args, fmt.Sprintf("%q", in.Str))
+		}
+	}
+	return strings.TrimSpace(opcode.NewOpcode(in.Op).String() + " " + strings.Join(args, ", "))
+}
+
+// instruction returns the Go code which implements a single instruction.
+//
+// next is the address of the instruction which follows it.
+func (t *Translator) instruction(in opcode.Instruction, next int) string {
+	a := in.Args
+	reg := func(i int) string {
+		return fmt.Sprintf("r[%d]", a[i])
+	}
+
+	// fail returns the code to raise a fault of the given kind.
+	fail := func(kind string, msg string) string {
+		t.uses["fault"] = true
+		t.uses["fmt"] = true
+		t.uses["os"] = true
+		return fmt.Sprintf("fault(0x%04X, &cpu.Fault{Kind: cpu.%s, Message: %s})", next-in.Size, kind, msg)
+	}
+
+	// raise returns the code to report the error held in err as a fault.
+	raise := func() string {
+		t.uses["fault"] = true
+		t.uses["fmt"] = true
+		t.uses["os"] = true
+		return fmt.Sprintf("fault(0x%04X, err)", next-in.Size)
+	}
+
+	// getInt and getString return the code to fetch the contents of a
+	// register, faulting if it holds the wrong type, and setString the
+	// code to store a string within our limits.
+	getInt := func(i int) string {
+		t.uses["getInt"] = true
+		raise()
+		return fmt.Sprintf("getInt(c, 0x%04X, %d)", next-in.Size, a[i])
+	}
+	getString := func(i int) string {
+		t.uses["getString"] = true
+		raise()
+		return fmt.Sprintf("getString(c, 0x%04X, %d)", next-in.Size, a[i])
+	}
+	setString := func(i int, expr string) string {
+		t.uses["setString"] = true
+		raise()
+		return fmt.Sprintf("setString(c, 0x%04X, %d, %s)\n", next-in.Size, a[i], expr)
+	}
+
+	// The math operations, in both their register and immediate forms.
+	op, b := int(in.Op), ""
+	if _, ok := mathExprs[op]; ok {
+		b = getInt(2)
+	} else if _, ok := mathExprs[op-opcode.IMMEDIATE]; ok && op >= opcode.IMMEDIATE {
+		op, b = op-opcode.IMMEDIATE, fmt.Sprintf("%d", t.width.Wrap(a[2]))
+	} else if _, ok := mathExprs[op-opcode.IMMEDIATE_SHORT]; ok && op >= opcode.IMMEDIATE_SHORT {
+		op, b = op-opcode.IMMEDIATE_SHORT, fmt.Sprintf("%d", t.width.Wrap(a[2]))
+	}
+	if b != "" {
+		t.uses["flags"] = true
+		t.uses["width"] = true
+
+		// The operands are fetched in order before dividing, so the
+		// faults match those of the interpreter.
+		check := ""
+		if op == opcode.DIV_OP || op == opcode.MOD_OP {
+			check = fmt.Sprintf("if _, d := %s, %s; d == 0 {\n%s\n}\n", getInt(1), b, fail("DivideFault", `"Attempting to divide by zero - denying"`))
+		}
+		expr := fmt.Sprintf(mathExprs[op], getInt(1), b)
+		return fmt.Sprintf("%sv, f = %s\n%s.SetInt(v)\n", check, expr, reg(0))
+	}
+
+	switch int(in.Op) {
+	case opcode.EXIT:
+		return "return\n"
+
+	case opcode.EXIT_IMMEDIATE:
+		t.uses["os"] = true
+		if a[0] > opcode.MaxStatus {
+			return fail("StatusFault", fmt.Sprintf(`"Exit status %d is outside the range 0-%d"`, a[0], opcode.MaxStatus)) + "\n"
+		}
+		return fmt.Sprintf("os.Exit(%d)\n", a[0])
+
+	case opcode.EXIT_REG:
+		t.uses["os"] = true
+		return fmt.Sprintf("if s := %s; s < 0 || s > %d {\n%s\n}\nos.Exit(%s.GetInt())\n", getInt(0), opcode.MaxStatus,
+			fail("StatusFault", fmt.Sprintf(`fmt.Sprintf("Exit status %%d is outside the range 0-%d", s)`, opcode.MaxStatus)), reg(0))
+
+	case opcode.NOP_OP:
+		return ""
+
+	case opcode.INT_STORE, opcode.INT_STORE_WIDE:
+		return fmt.Sprintf("%s.SetInt(%d)\n", reg(0), t.width.Wrap(a[1]))
+
+	case opcode.INT_PRINT:
+		t.uses["fmt"] = true
+		return fmt.Sprintf(`if v := %s; v < 256 {
+	fmt.Printf("%%02X", v)
+} else {
+	fmt.Printf("%%04X", v)
+}
+`, getInt(0))
+
+	case opcode.INT_PRINT_FMT:
+		t.uses["fmt"] = true
+		t.uses["width"] = true
+		return fmt.Sprintf(`if s, err := w.Format(%s, 0x%04X); err == nil {
+	fmt.Printf("%%s", s)
+} else {
+	%s
+}
+`, getInt(0), a[1], raise())
+
+	case opcode.INT_TOSTRING:
+		t.uses["fmt"] = true
+		return setString(0, fmt.Sprintf("fmt.Sprintf(\"%%d\", %s)", getInt(0)))
+
+	case opcode.INT_RANDOM:
+		t.uses["math/rand"] = true
+		t.uses["time"] = true
+		return fmt.Sprintf("%s.SetInt(rand.New(rand.NewSource(time.Now().UnixNano())).Intn(0xffff))\n", reg(0))
+
+	case opcode.JUMP_TO:
+		return fmt.Sprintf("pc = 0x%04X\ncontinue\n", a[0])
+
+	case opcode.JUMP_Z, opcode.JUMP_NZ, opcode.JUMP_L, opcode.JUMP_G,
+		opcode.JUMP_LE, opcode.JUMP_GE, opcode.JUMP_C, opcode.JUMP_NC:
+		t.uses["flags"] = true
+		t.uses["width"] = true
+		return fmt.Sprintf("if %s {\npc = 0x%04X\ncontinue\n}\npc = 0x%04X\ncontinue\n", conditions[int(in.Op)], a[0], next)
+
+	case opcode.INC_OP, opcode.DEC_OP, opcode.NOT_OP, opcode.NEG_OP:
+		t.uses["flags"] = true
+		t.uses["width"] = true
+		expr := fmt.Sprintf(unaryExprs[int(in.Op)], getInt(0))
+		return fmt.Sprintf("v, f = %s\n%s.SetInt(v)\n", expr, reg(0))
+
+	case opcode.STRING_STORE:
+		return setString(0, fmt.Sprintf("%q", in.Str))
+
+	case opcode.STRING_PRINT:
+		t.uses["fmt"] = true
+		return fmt.Sprintf("fmt.Printf(\"%%s\", %s)\n", getString(0))
+
+	case opcode.STRING_CONCAT:
+		return setString(0, fmt.Sprintf("%s + %s", getString(1), getString(2)))
+
+	case opcode.STRING_TOINT:
+		t.uses["strconv"] = true
+		t.uses["width"] = true
+		return fmt.Sprintf(`{
+	s := %s
+	if i, err := strconv.Atoi(s); err == nil {
+		%s.SetInt(w.Wrap(i))
+	} else {
+		%s
+	}
+}
+`, getString(0), reg(0), fail("ConversionFault", `fmt.Sprintf("Failed to convert '%s' to int: %s", s, err.Error())`))
+
+	case opcode.STRING_TOINT_BASE:
+		t.uses["width"] = true
+		return fmt.Sprintf(`if i, err := cpu.ParseInt(%s, %d); err == nil {
+	%s.SetInt(w.Wrap(i))
+} else {
+	%s
+}
+`, getString(0), a[1], reg(0), raise())
+
+	case opcode.STRING_LENGTH:
+		t.uses["width"] = true
+		return fmt.Sprintf("%s.SetInt(w.Wrap(len(%s)))\n", reg(0), getString(1))
+
+	case opcode.STRING_SUBSTR:
+		return setString(0, fmt.Sprintf("cpu.Substr(%s, %s, %s)", getString(1), getInt(2), getInt(3)))
+
+	case opcode.STRING_INDEX:
+		t.uses["flags"] = true
+		t.uses["width"] = true
+		t.uses["strings"] = true
+		return fmt.Sprintf(`v = strings.Index(%s, %s)
+f = cpu.Flags{}
+f.SetZero(v >= 0)
+%s.SetInt(w.Wrap(v))
+`, getString(1), getString(2), reg(0))
+
+	case opcode.STRING_CHARAT:
+		return fmt.Sprintf(`if ch, err := cpu.CharAt(%s, %s); err == nil {
+	%s.SetInt(ch)
+} else {
+	%s
+}
+`, getString(1), getInt(2), reg(0), raise())
+
+	case opcode.STRING_CHR:
+		return setString(0, fmt.Sprintf("cpu.Chr(%s)", getInt(1)))
+
+	case opcode.STRING_UPPER, opcode.STRING_LOWER, opcode.STRING_TRIM:
+		t.uses["strings"] = true
+		return setString(0, fmt.Sprintf("strings.%s(%s)", stringFuncs[int(in.Op)], getString(0)))
+
+	case opcode.STRING_SPLIT:
+		t.uses["width"] = true
+		return fmt.Sprintf(`{
+	parts := cpu.Split(%s, %s)
+	values := make([]cpu.Object, len(parts))
+	for i, part := range parts {
+		values[len(parts)-1-i] = &cpu.StringObject{Value: part}
+	}
+	if err := c.Push(values...); err != nil {
+		%s
+	}
+	%s.SetInt(w.Wrap(len(parts)))
+}
+`, getString(1), getString(2), raise(), reg(0))
+
+	case opcode.CMP_REG:
+		t.uses["flags"] = true
+		t.uses["width"] = true
+		return fmt.Sprintf(`f = cpu.Flags{}
+switch %s.Type() {
+case "int":
+	_, f = w.Sub(%s.GetInt(), %s)
+case "string":
+	f.SetZero(%s.GetString() == %s)
+}
+`, reg(0), reg(0), getInt(1), reg(0), getString(1))
+
+	case opcode.CMP_IMMEDIATE, opcode.CMP_IMMEDIATE_WIDE:
+		t.uses["flags"] = true
+		t.uses["width"] = true
+		return fmt.Sprintf(`f = cpu.Flags{}
+if %s.Type() == "int" {
+	_, f = w.Sub(%s.GetInt(), %d)
+}
+`, reg(0), reg(0), a[1])
+
+	case opcode.CMP_STRING:
+		t.uses["flags"] = true
+		return fmt.Sprintf("f = cpu.Flags{}\nf.SetZero(%s.Type() == \"string\" && %s.GetString() == %q)\n", reg(0), reg(0), in.Str)
+
+	case opcode.IS_STRING:
+		t.uses["flags"] = true
+		return fmt.Sprintf("f.SetZero(%s.Type() == \"string\")\n", reg(0))
+
+	case opcode.IS_INTEGER:
+		t.uses["flags"] = true
+		return fmt.Sprintf("f.SetZero(%s.Type() == \"int\")\n", reg(0))
+
+	case opcode.REG_STORE:
+		return fmt.Sprintf(`switch %s.Type() {
+case "string":
+	%s
+case "int":
+	%s.SetInt(%s.GetInt())
+default:
+	%s
+}
+`, reg(1), setString(0, reg(1)+".GetString()"), reg(0), reg(1), fail("TypeFault", `"Invalid register type?"`))
+
+	case opcode.PEEK:
+		t.uses["memory"] = true
+		return fmt.Sprintf(`if data, err := c.LoadMem(%s, 1); err == nil {
+	%s.SetInt(int(data[0]))
+} else {
+	%s
+}
+`, getInt(1), reg(0), raise())
+
+	case opcode.POKE, opcode.POKE_WORD:
+		t.uses["memory"] = true
+		n, data := 1, "[]byte{byte(n)}"
+		if int(in.Op) == opcode.POKE_WORD {
+			n, data = 2, "[]byte{byte(n), byte(n >> 8)}"
+		}
+		return fmt.Sprintf(`{
+	addr, n := %s, %s
+	if err := cpu.CheckValue(n, %d); err != nil {
+		%s
+	}
+	if err := c.StoreMem(addr, %s); err != nil {
+		%s
+	}
+}
+`, getInt(1), getInt(0), n, raise(), data, raise())
+
+	case opcode.MEMSET:
+		t.uses["memory"] = true
+		t.uses["bytes"] = true
+		return fmt.Sprintf(`{
+	addr, n := %s, %s
+	if err := cpu.CheckValue(n, 1); err != nil {
+		%s
+	}
+	length := %s
+	if length < 0 {
+		%s
+	}
+	if err := c.StoreMem(addr, bytes.Repeat([]byte{byte(n)}, length)); err != nil {
+		%s
+	}
+}
+`, getInt(0), getInt(1), raise(), getInt(2), fail("IndexFault", `fmt.Sprintf("Invalid length %d", length)`), raise())
+
+	case opcode.MEMCPY:
+		t.uses["memory"] = true
+		return fmt.Sprintf(`{
+	src, dst, length := %s, %s, %s
+	data, err := c.LoadMem(src, length)
+	if err != nil {
+		%s
+	}
+	if err = c.StoreMem(dst, data); err != nil {
+		%s
+	}
+}
+`, getInt(1), getInt(0), getInt(2), raise(), raise())
+
+	case opcode.STACK_PUSH:
+		return fmt.Sprintf("if err := c.Push(%s.Object()); err != nil {\n%s\n}\n", reg(0), raise())
+
+	case opcode.STACK_POP:
+		return fmt.Sprintf("if err := c.Pop(%d); err != nil {\n%s\n}\n", a[0], raise())
+
+	case opcode.STACK_CALL:
+		t.uses["calls"] = true
+		return fmt.Sprintf(`if max := c.Limits().MaxStack; max > 0 && len(calls) >= max {
+	%s
+}
+calls = append(calls, 0x%04X)
+pc = 0x%04X
+continue
+`, fail("StackOverflowFault", `"Call Stack Overflow!"`), next, a[0])
+
+	case opcode.STACK_RET:
+		t.uses["calls"] = true
+		return fmt.Sprintf(`if len(calls) == 0 {
+	%s
+}
+pc = calls[len(calls)-1]
+calls = calls[:len(calls)-1]
+continue
+`, fail("StackFault", `"Return without a call"`))
+
+	case opcode.READ_LINE, opcode.READ_CHAR:
+		t.uses["flags"] = true
+		return fmt.Sprintf("{\ns, fl := c.%s()\n%sf = fl\n}\n", inputFuncs[int(in.Op)], setString(0, "s"))
+
+	case opcode.READ_BYTE, opcode.READ_INT:
+		t.uses["flags"] = true
+		t.uses["width"] = true
+		return fmt.Sprintf("v, f = c.%s()\n%s.SetInt(w.Wrap(v))\n", inputFuncs[int(in.Op)], reg(0))
+
+	case opcode.TRAP_OP:
+		return fmt.Sprintf("if err := cpu.TRAPS[0x%04X](c, 0x%04X); err != nil {\n%s\n}\n", a[0], a[0], raise())
+	}
+
+	// disassemble() ensures we never get here.
+	panic(fmt.Sprintf("untranslatable instruction %02X", in.Op))
+}
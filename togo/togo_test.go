@@ -0,0 +1,325 @@
+package togo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/skx/go.vm/compiler"
+	"github.com/skx/go.vm/cpu"
+	"github.com/skx/go.vm/lexer"
+)
+
+// compile turns the given source into bytecode.
+func compile(src string) []byte {
+	e := compiler.New(lexer.New(src))
+	e.Compile()
+	return e.Output()
+}
+
+// Test that a simple program is translated.
+func TestTranslate(t *testing.T) {
+	tr := New(compile(`
+        store #1, 3
+        store #2, 1
+:loop
+        call show
+        sub #1, #1, #2
+        jmpnz loop
+        exit
+:show
+        print_int #1
+        ret
+`))
+
+	src, err := tr.Translate()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ok, reason := tr.Translated(); !ok {
+		t.Fatalf("program was not translated: %s", reason)
+	}
+
+	for _, expected := range []string{"case 0x0000:", "calls = append(calls, ", "pc = 0x0008"} {
+		if !strings.Contains(string(src), expected) {
+			t.Errorf("generated code did not contain %q:\n%s", expected, src)
+		}
+	}
+	if strings.Contains(string(src), "c.Run()") {
+		t.Errorf("generated code embeds the interpreter")
+	}
+}
+
+// Test that writes to RAM outside the program are translated.
+func TestWrites(t *testing.T) {
+	tr := New(compile(`
+        store #1, 0x41
+        store #2, 0x1000
+        poke #1, #2
+        store #3, 0x1001
+        store #4, 10
+        memset #3, #1, #4
+        peek #5, #2
+        exit
+`))
+
+	src, err := tr.Translate()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ok, reason := tr.Translated(); !ok {
+		t.Fatalf("program was not translated: %s", reason)
+	}
+	for _, expected := range []string{"c.StoreMem(0, program)", "cpu.CheckValue(n, 1)", "c.LoadMem(getInt(c, 0x0017, 2), 1)"} {
+		if !strings.Contains(string(src), expected) {
+			t.Errorf("generated code did not contain %q:\n%s", expected, src)
+		}
+	}
+}
+
+// Test that programs which use more registers than the default are
+// given them.
+func TestRegisters(t *testing.T) {
+	e := compiler.New(lexer.New("store #40, 4\nexit #40\n"))
+	err := e.SetRegisters(41)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	e.Compile()
+
+	tr := New(e.Output())
+	src, err := tr.Translate()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ok, reason := tr.Translated(); !ok {
+		t.Fatalf("program was not translated: %s", reason)
+	}
+	if !strings.Contains(string(src), "c.SetRegisters(41)") {
+		t.Errorf("generated code did not set the registers:\n%s", src)
+	}
+}
+
+// Test that self-modifying programs fall back to the interpreter.
+func TestFallback(t *testing.T) {
+
+	tests := []string{
+		"store #1, 1\nstore #2, 2\npoke #1, #2\nexit\n",
+		"store #1, 1\nstore #2, 2\nmemcpy #1, #2, #1\nexit\n",
+		"store #1, 1\nstore #2, 0x1000\ninc #2\npoke #1, #2\nexit\n",
+		"store #1, 1\nstore #2, 0x1000\n:again\npoke #1, #2\njmp again\n",
+		"store #1, 0x1000\nstore #2, 0\nmemcpy #1, #2, #3\nexit\n",
+		"DB 0xFE\n",
+		"section \"rx\"\nexit\n",
+		"ei\nexit\n",
+	}
+
+	for _, test := range tests {
+		tr := New(compile(test))
+
+		src, err := tr.Translate()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if ok, _ := tr.Translated(); ok {
+			t.Errorf("program was translated: %s", test)
+		}
+		if !strings.Contains(string(src), "c.Run()") {
+			t.Errorf("generated code does not embed the interpreter:\n%s", src)
+		}
+	}
+}
+
+// Test that programs with wide integers wrap them to the same width.
+func TestWidth(t *testing.T) {
+	tr := New(compile("bits 32\nstore #1, -100000\nstore #2, 2\nmul #0, #1, #2\nexit #0\n"))
+
+	src, err := tr.Translate()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ok, reason := tr.Translated(); !ok {
+		t.Fatalf("program was not translated: %s", reason)
+	}
+
+	for _, expected := range []string{"c.SetWidth(32)", "r[1].SetInt(-100000)", "w.Mul(getInt(c, 0x000E, 1), getInt(c, 0x000E, 2))"} {
+		if !strings.Contains(string(src), expected) {
+			t.Errorf("generated code did not contain %q:\n%s", expected, src)
+		}
+	}
+}
+
+// Test that the string instructions are translated.
+func TestStrings(t *testing.T) {
+	tr := New(compile("store #1, \"a,b\"\nstore #2, \",\"\nsplit #0, #1, #2\nupper #1\nstring2int #1, 16\n"))
+
+	src, err := tr.Translate()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if ok, reason := tr.Translated(); !ok {
+		t.Fatalf("program was not translated: %s", reason)
+	}
+
+	for _, expected := range []string{"cpu.Split(getString(c, 0x000C, 1), getString(c, 0x000C, 2))", "strings.ToUpper(getString(c, 0x0010, 1))", "cpu.ParseInt(getString(c, 0x0012, 1), 16)"} {
+		if !strings.Contains(string(src), expected) {
+			t.Errorf("generated code did not contain %q:\n%s", expected, src)
+		}
+	}
+}
+
+// Test that the generated program passes its arguments, environment and
+// policy to the CPU.
+func TestSetup(t *testing.T) {
+	for _, prog := range []string{"exit\n", "store #1, \"true\"\nsystem #1\nexit\n"} {
+		tr := New(compile(prog))
+		tr.SetEnv([]string{"HOME"})
+		tr.SetSystemPolicy(cpu.SystemPolicy{Enabled: true, Allow: []string{"true"}})
+
+		src, err := tr.Translate()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+
+		for _, expected := range []string{"setup(c)", "c.SetArgs(os.Args)", `names := []string{"HOME"}`, `Allow: []string{"true"}`, "c.SetSystemPolicy(policy)"} {
+			if !strings.Contains(string(src), expected) {
+				t.Errorf("generated code did not contain %q:\n%s", expected, src)
+			}
+		}
+	}
+
+	// Without a policy no commands may be run.
+	src, err := New(compile("exit\n")).Translate()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if strings.Contains(string(src), "SetSystemPolicy") {
+		t.Errorf("generated code permits commands:\n%s", src)
+	}
+}
+
+// interpret runs the given program via the interpreter, returning the
+// output and status the go.vm command would.
+func interpret(t *testing.T, program []byte) (string, int) {
+	tmp, err := ioutil.TempFile("", "togo")
+	if err != nil {
+		t.Fatalf("failed to create a temporary file: %s", err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	c := cpu.NewCPU()
+	c.SetArgs([]string{"prog"})
+	err = c.LoadBytes(program)
+	if err != nil {
+		t.Fatalf("failed to load program: %s", err.Error())
+	}
+
+	orig := os.Stdout
+	os.Stdout = tmp
+	status, err := c.Run()
+	if f, ok := err.(*cpu.Fault); ok {
+		fmt.Printf("%s\n", f.Error())
+		status = f.ExitCode()
+	}
+	os.Stdout = orig
+
+	out, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatalf("failed to read output: %s", err.Error())
+	}
+	return string(out), status
+}
+
+// Test that generated programs build, and behave as the interpreter does.
+func TestBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping build in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is not installed")
+	}
+
+	tests := []struct {
+		name string
+		file string
+		src  string
+	}{
+		{"hello", "../examples/hello.in", ""},
+		{"loop", "../examples/loop.in", ""},
+		{"call", "../examples/call.in", ""},
+		{"math", "../examples/math.in", ""},
+		{"format", "../examples/format.in", ""},
+		{"stack", "../examples/stack.in", ""},
+		{"types", "../examples/types.in", ""},
+		{"map", "../examples/map.in", ""},
+		{"status", "", "store #1, 42\nexit #1\n"},
+		{"divide", "", "store #1, 1\nstore #2, 0\ndiv #3, #1, #2\nexit\n"},
+		{"divide-type", "", "store #1, \"x\"\nstore #2, 0\ndiv #3, #1, #2\nexit\n"},
+		{"inc-type", "", "store #1, \"x\"\ninc #1\nexit\n"},
+		{"cmp-type", "", "store #1, 1\nstore #2, \"x\"\ncmp #1, #2\nexit\n"},
+		{"print-type", "", "store #1, 1\nprint_str #1\nexit\n"},
+		{"string-limit", "", "store #1, \"ab\"\n:again\nconcat #1, #1, #1\njmp again\n"},
+		{"stack-limit", "", "store #1, 1\n:again\npush #1\njmp again\n"},
+		{"call-limit", "", "nop\n:again\ncall again\n"},
+		{"pop-empty", "", "pop #1\nexit\n"},
+		{"memory", "", "store #1, 0x1234\nstore #2, 0x1000\npokew #1, #2\nstore #3, 0x2000\nstore #4, 3\nmemcpy #3, #2, #4\npeek #5, #3\nprint_int #5\nstore #6, 0x1002\nstore #7, 0x41\nmemset #6, #7, #4\nstore #8, 0x1003\npeek #9, #8\nprint_int #9\nexit\n"},
+		{"poke-value", "", "store #1, 0x100\nstore #2, 0x1000\npoke #1, #2\nexit\n"},
+		{"poke-outside", "", "store #1, 1\nstore #2, 0xFFFF\npokew #1, #2\nexit\n"},
+		{"memset-length", "", "store #1, 0x1000\nstore #2, 1\nstore #3, -1\nmemset #1, #2, #3\nexit\n"},
+		{"peek-outside", "", "store #1, 0x10000\npeek #2, #1\nexit\n"},
+	}
+
+	// The generated programs must be built within this module.
+	dir, derr := ioutil.TempDir(".", "_build")
+	if derr != nil {
+		t.Fatalf("failed to create a temporary directory: %s", derr.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		src := test.src
+		if test.file != "" {
+			data, err := ioutil.ReadFile(test.file)
+			if err != nil {
+				t.Fatalf("failed to read %s: %s", test.file, err.Error())
+			}
+			src = string(data)
+		}
+		program := compile(src)
+
+		code, err := New(program).Translate()
+		if err != nil {
+			t.Fatalf("failed to translate %s: %s", test.name, err.Error())
+		}
+
+		pkg := filepath.Join(dir, test.name)
+		os.Mkdir(pkg, 0755)
+		err = ioutil.WriteFile(filepath.Join(pkg, "main.go"), code, 0644)
+		if err != nil {
+			t.Fatalf("failed to write %s: %s", test.name, err.Error())
+		}
+		bin := filepath.Join(pkg, test.name)
+		out, err := exec.Command("go", "build", "-o", bin, "./"+pkg).CombinedOutput()
+		if err != nil {
+			t.Fatalf("failed to build %s: %s\n%s", test.name, err.Error(), out)
+		}
+
+		status := 0
+		out, err = exec.Command(bin).Output()
+		if exit, ok := err.(*exec.ExitError); ok {
+			status = exit.ExitCode()
+		} else if err != nil {
+			t.Fatalf("failed to run %s: %s", test.name, err.Error())
+		}
+
+		expected, expectedStatus := interpret(t, program)
+		if string(out) != expected || status != expectedStatus {
+			t.Errorf("%s gave %q with status %d, the interpreter gave %q with status %d", test.name, out, status, expected, expectedStatus)
+		}
+	}
+}
@@ -30,6 +30,14 @@ const (
 	OR  = "OR"
 	SUB = "SUB"
 	XOR = "XOR"
+	MOD = "MOD"
+	SHL = "SHL"
+	SHR = "SHR"
+	SAR = "SAR"
+	ROL = "ROL"
+	ROR = "ROR"
+	NOT = "NOT"
+	NEG = "NEG"
 
 	// control-flow
 	CALL  = "CALL"
@@ -37,18 +45,66 @@ const (
 	JMP   = "JMP"
 	JMPNZ = "JMPNZ"
 	JMPZ  = "JMPZ"
+	JL    = "JL"
+	JG    = "JG"
+	JLE   = "JLE"
+	JGE   = "JGE"
+	JC    = "JC"
+	JNC   = "JNC"
 	RET   = "RET"
 
+	// interrupts
+	EI   = "EI"
+	DI   = "DI"
+	IRET = "IRET"
+
 	// stack
-	PUSH = "PUSH"
-	POP  = "POP"
+	PUSH  = "PUSH"
+	POP   = "POP"
+	ENTER = "ENTER"
+	LEAVE = "LEAVE"
+	LOAD  = "LOAD"
+	SAVE  = "SAVE"
+	GETSP = "GETSP"
+	SETSP = "SETSP"
 
 	// types
 	IS_STRING  = "IS_STRING"
 	IS_INTEGER = "IS_INTEGER"
 	STRING2INT = "STRING2INT"
+	IS_BYTES   = "IS_BYTES"
+	IS_MAP     = "IS_MAP"
 	INT2STRING = "INT2STRING"
 
+	// byte-arrays
+	BYTES        = "BYTES"
+	GETBYTE      = "GETBYTE"
+	SETBYTE      = "SETBYTE"
+	SLICE        = "SLICE"
+	APPEND       = "APPEND"
+	BYTELEN      = "BYTELEN"
+	BYTES2STRING = "BYTES2STRING"
+	STRING2BYTES = "STRING2BYTES"
+
+	// maps
+	MAP    = "MAP"
+	MAPGET = "MAPGET"
+	MAPSET = "MAPSET"
+	MAPDEL = "MAPDEL"
+	HASKEY = "HASKEY"
+	KEYS   = "KEYS"
+
+	// strings
+	STRLEN  = "STRLEN"
+	SUBSTR  = "SUBSTR"
+	INDEXOF = "INDEXOF"
+	CHARAT  = "CHARAT"
+	CHR     = "CHR"
+	UPPER   = "UPPER"
+	LOWER   = "LOWER"
+	TRIM    = "TRIM"
+	SPLIT   = "SPLIT"
+
 	// compare
 	CMP = "CMP"
 
@@ -59,20 +115,34 @@ const (
 	PRINT_INT = "PRINT_INT"
 	PRINT_STR = "PRINT_STR"
 
+	// input
+	READ_LINE = "READ_LINE"
+	READ_BYTE = "READ_BYTE"
+	READ_CHAR = "READ_CHAR"
+	READ_INT  = "READ_INT"
+
 	// memory
-	PEEK = "PEEK"
-	POKE = "POKE"
+	PEEK      = "PEEK"
+	POKE      = "POKE"
+	PEEKW     = "PEEKW"
+	POKEW     = "POKEW"
+	STORE_MEM = "STORE_MEM"
+	LOAD_MEM  = "LOAD_MEM"
+	MEMSET    = "MEMSET"
+	MEMCMP    = "MEMCMP"
 
 	// Misc
-	CONCAT = "CONCAT"
-	DATA   = "DATA"
-	DB     = "DB"
-	EXIT   = "EXIT"
-	MEMCPY = "MEMCPY"
-	NOP    = "NOP"
-	RANDOM = "RANDOM"
-	SYSTEM = "SYSTEM"
-	TRAP   = "TRAP"
+	BITS    = "BITS"
+	CONCAT  = "CONCAT"
+	DATA    = "DATA"
+	DB      = "DB"
+	EXIT    = "EXIT"
+	MEMCPY  = "MEMCPY"
+	NOP     = "NOP"
+	RANDOM  = "RANDOM"
+	SECTION = "SECTION"
+	SYSTEM  = "SYSTEM"
+	TRAP    = "TRAP"
 )
 
 // reversed keywords
@@ -84,9 +154,40 @@ var keywords = map[string]Type{
 	// types
 	"is_integer": IS_INTEGER,
 	"is_string":  IS_STRING,
+	"is_bytes":   IS_BYTES,
+	"is_map":     IS_MAP,
 	"int2string": INT2STRING,
 	"string2int": STRING2INT,
 
+	// byte-arrays
+	"bytes":        BYTES,
+	"getbyte":      GETBYTE,
+	"setbyte":      SETBYTE,
+	"slice":        SLICE,
+	"append":       APPEND,
+	"bytelen":      BYTELEN,
+	"bytes2string": BYTES2STRING,
+	"string2bytes": STRING2BYTES,
+
+	// maps
+	"map":    MAP,
+	"mapget": MAPGET,
+	"mapset": MAPSET,
+	"mapdel": MAPDEL,
+	"haskey": HASKEY,
+	"keys":   KEYS,
+
+	// strings
+	"strlen":  STRLEN,
+	"substr":  SUBSTR,
+	"indexof": INDEXOF,
+	"charat":  CHARAT,
+	"chr":     CHR,
+	"upper":   UPPER,
+	"lower":   LOWER,
+	"trim":    TRIM,
+	"split":   SPLIT,
+
 	// store
 	"store": STORE,
 
@@ -94,6 +195,12 @@ var keywords = map[string]Type{
 	"print_int": PRINT_INT,
 	"print_str": PRINT_STR,
 
+	// input
+	"read_line": READ_LINE,
+	"read_byte": READ_BYTE,
+	"read_char": READ_CHAR,
+	"read_int":  READ_INT,
+
 	// math
 	"add": ADD,
 	"and": AND,
@@ -104,6 +211,14 @@ var keywords = map[string]Type{
 	"or":  OR,
 	"sub": SUB,
 	"xor": XOR,
+	"mod": MOD,
+	"shl": SHL,
+	"shr": SHR,
+	"sar": SAR,
+	"rol": ROL,
+	"ror": ROR,
+	"not": NOT,
+	"neg": NEG,
 
 	// control-flow
 	"call":  CALL,
@@ -111,26 +226,51 @@ var keywords = map[string]Type{
 	"jmp":   JMP,
 	"jmpnz": JMPNZ,
 	"jmpz":  JMPZ,
+	"jl":    JL,
+	"jg":    JG,
+	"jle":   JLE,
+	"jge":   JGE,
+	"jc":    JC,
+	"jnc":   JNC,
 	"ret":   RET,
 
+	// interrupts
+	"ei":   EI,
+	"di":   DI,
+	"iret": IRET,
+
 	// stack
-	"push": PUSH,
-	"pop":  POP,
+	"push":  PUSH,
+	"pop":   POP,
+	"enter": ENTER,
+	"leave": LEAVE,
+	"load":  LOAD,
+	"save":  SAVE,
+	"getsp": GETSP,
+	"setsp": SETSP,
 
 	// memory
-	"peek": PEEK,
-	"poke": POKE,
+	"peek":      PEEK,
+	"poke":      POKE,
+	"peekw":     PEEKW,
+	"pokew":     POKEW,
+	"store_mem": STORE_MEM,
+	"load_mem":  LOAD_MEM,
+	"memset":    MEMSET,
+	"memcmp":    MEMCMP,
 
 	// misc
-	"exit":   EXIT,
-	"concat": CONCAT,
-	"DATA":   DATA,
-	"DB":     DB,
-	"int":    TRAP,
-	"memcpy": MEMCPY,
-	"nop":    NOP,
-	"random": RANDOM,
-	"system": SYSTEM,
+	"exit":    EXIT,
+	"bits":    BITS,
+	"concat":  CONCAT,
+	"DATA":    DATA,
+	"DB":      DB,
+	"int":     TRAP,
+	"memcpy":  MEMCPY,
+	"nop":     NOP,
+	"random":  RANDOM,
+	"section": SECTION,
+	"system":  SYSTEM,
 }
 
 // LookupIdentifier used to determinate whether identifier is keyword nor not